@@ -0,0 +1,166 @@
+package RustScan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultCheckpointInterval is used when WithCheckpoint is given a non-positive interval.
+const defaultCheckpointInterval = 10 * time.Second
+
+// Checkpoint is the progress of a scan, saved periodically by WithCheckpoint.
+//
+// A scan may run several RustScan processes, one per command line of Invocations, and
+// its checkpoint covers all of them: Completed holds the indexes in Invocations of the
+// processes that succeeded, and Open the open ports found so far by any of them.
+// RustScan only reports the ports it finds open, so an unfinished scan can be resumed by
+// scanning Open with WithHostPortPairs to get the nmap results of the ports already
+// found, while the invocations that did not complete have to be run again.
+type Checkpoint struct {
+	Invocations [][]string `json:"invocations"`
+	Completed   []int      `json:"completed"`
+	Open        []string   `json:"open"`
+	Finished    bool       `json:"finished"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ReadCheckpoint reads a checkpoint written by a scan that used WithCheckpoint.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return &checkpoint, nil
+}
+
+// checkpointer writes the checkpoint of a running scan at a regular interval. A scan
+// has a single checkpointer, shared by all its processes.
+type checkpointer struct {
+	path     string
+	interval time.Duration
+
+	mutex      sync.Mutex
+	checkpoint Checkpoint
+	seen       map[string]bool
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func newCheckpointer(path string, interval time.Duration, invocations [][]string) *checkpointer {
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	return &checkpointer{
+		path:       path,
+		interval:   interval,
+		checkpoint: Checkpoint{Invocations: invocations},
+		seen:       make(map[string]bool),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// start writes a first checkpoint and keeps writing one at every interval until stop
+// is called.
+func (c *checkpointer) start() error {
+	if err := c.write(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(c.stopped)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				// A failed write is retried at the next tick and reported by stop.
+				_ = c.write()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// add records an open port, once even when a retried process finds it again. It does
+// nothing on a nil checkpointer.
+func (c *checkpointer) add(port openPort) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	open := net.JoinHostPort(port.addr, strconv.Itoa(int(port.port)))
+	if !c.seen[open] {
+		c.seen[open] = true
+		c.checkpoint.Open = append(c.checkpoint.Open, open)
+	}
+}
+
+// complete records that the process of the invocation at idx succeeded. It does nothing
+// on a nil checkpointer.
+func (c *checkpointer) complete(idx int) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.checkpoint.Completed = append(c.checkpoint.Completed, idx)
+}
+
+// stop stops the periodic writes and writes the final checkpoint.
+func (c *checkpointer) stop(finished bool) error {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		<-c.stopped
+	})
+
+	c.mutex.Lock()
+	c.checkpoint.Finished = finished
+	c.mutex.Unlock()
+
+	return c.write()
+}
+
+// write saves the checkpoint to a temporary file which is then renamed, so that a crash
+// never leaves a partially written checkpoint behind.
+func (c *checkpointer) write() error {
+	c.mutex.Lock()
+	c.checkpoint.UpdatedAt = time.Now()
+	content, err := json.Marshal(c.checkpoint)
+	c.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
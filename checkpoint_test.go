@@ -0,0 +1,55 @@
+package RustScan
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWithCheckpoint(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "scan.checkpoint")
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The ports are split across two processes, which share the checkpoint of the scan.
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("1-1000", "2000-65535"), WithCheckpoint(path, time.Hour))
+	if _, _, err := scanner.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkpoint, err := ReadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("unable to read checkpoint: %v", err)
+	}
+
+	if !checkpoint.Finished {
+		t.Error("expected the checkpoint of a finished scan")
+	}
+	if len(checkpoint.Invocations) != 2 {
+		t.Errorf("expected 2 invocations, got %v", checkpoint.Invocations)
+	}
+	if want := []int{0, 1}; !reflect.DeepEqual(checkpoint.Completed, want) {
+		t.Errorf("expected completed invocations %v, got %v", want, checkpoint.Completed)
+	}
+
+	open := append([]string(nil), checkpoint.Open...)
+	sort.Strings(open)
+	if want := []string{"10.0.0.1:1", "10.0.0.1:1000", "10.0.0.1:2000", "10.0.0.1:65535"}; !reflect.DeepEqual(open, want) {
+		t.Errorf("expected open ports %v, got %v", want, open)
+	}
+}
+
+func TestCheckpointerAddOnce(t *testing.T) {
+	c := newCheckpointer("unused", 0, nil)
+	c.add(openPort{addr: "::1", port: 22})
+	c.add(openPort{addr: "::1", port: 22})
+	c.add(openPort{addr: "10.0.0.1", port: 22})
+
+	if want := []string{"[::1]:22", "10.0.0.1:22"}; !reflect.DeepEqual(c.checkpoint.Open, want) {
+		t.Errorf("expected %v, got %v", want, c.checkpoint.Open)
+	}
+}
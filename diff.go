@@ -0,0 +1,113 @@
+package RustScan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PortTransition describes a port whose state changed between two runs.
+type PortTransition struct {
+	Address  string     `json:"address"`
+	Port     uint16     `json:"port"`
+	Protocol string     `json:"protocol"`
+	From     PortStatus `json:"from"`
+	To       PortStatus `json:"to"`
+}
+
+// DiffRuns returns the ports whose state differs between previous and current. A port
+// that is missing from a run, including every port of a host missing from it, is
+// considered closed since RustScan only reports the ports it found open. Either run may
+// be nil, in which case all of its ports are considered closed.
+func DiffRuns(previous, current *Run) []PortTransition {
+	before, beforeKeys := portStates(previous)
+	after, afterKeys := portStates(current)
+
+	var transitions []PortTransition
+	for _, key := range afterKeys {
+		if from := stateOr(before, key); from != after[key] {
+			transitions = append(transitions, key.transition(from, after[key]))
+		}
+	}
+
+	for _, key := range beforeKeys {
+		if _, ok := after[key]; !ok && before[key] != Closed {
+			transitions = append(transitions, key.transition(before[key], Closed))
+		}
+	}
+
+	return transitions
+}
+
+type portKey struct {
+	address  string
+	port     uint16
+	protocol string
+}
+
+func (k portKey) transition(from, to PortStatus) PortTransition {
+	return PortTransition{
+		Address:  k.address,
+		Port:     k.port,
+		Protocol: k.protocol,
+		From:     from,
+		To:       to,
+	}
+}
+
+// portStates maps every port of a run to its state, and returns the keys in the order
+// they appear in the run.
+func portStates(run *Run) (map[portKey]PortStatus, []portKey) {
+	states := make(map[portKey]PortStatus)
+	if run == nil {
+		return states, nil
+	}
+
+	var keys []portKey
+	for _, host := range run.Hosts {
+		for _, port := range host.Ports {
+			key := portKey{address: hostKey(host), port: port.ID, protocol: port.Protocol}
+			if _, ok := states[key]; !ok {
+				keys = append(keys, key)
+			}
+			states[key] = port.Status()
+		}
+	}
+
+	return states, keys
+}
+
+func stateOr(states map[portKey]PortStatus, key portKey) PortStatus {
+	if state, ok := states[key]; ok {
+		return state
+	}
+
+	return Closed
+}
+
+// Fingerprint returns a hash of the hosts, ports and services of the run. It ignores
+// timestamps, durations and the order of hosts and ports, so two runs with the same
+// findings have the same fingerprint: comparing the fingerprints of consecutive scans
+// tells whether anything changed without computing a full diff.
+func (r *Run) Fingerprint() string {
+	var lines []string
+
+	for _, host := range r.Hosts {
+		address := hostKey(host)
+		lines = append(lines, fmt.Sprintf("host %s %s", address, host.Status.State))
+
+		for _, port := range host.Ports {
+			lines = append(lines, fmt.Sprintf("port %s %d/%s %s %s %s %s %s",
+				address, port.ID, port.Protocol, port.State.State,
+				port.Service.Name, port.Service.Product, port.Service.Version, port.Service.ExtraInfo,
+			))
+		}
+	}
+
+	sort.Strings(lines)
+
+	hash := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(hash[:])
+}
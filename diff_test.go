@@ -0,0 +1,120 @@
+package RustScan
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPort is a port of a host in a Run built by newTestRun.
+type testPort struct {
+	addr  string
+	id    uint16
+	state PortStatus
+}
+
+// newTestRun builds a Run with the given ports, grouping them by host in order.
+func newTestRun(ports ...testPort) *Run {
+	run := &Run{}
+	hosts := make(map[string]int)
+
+	for _, port := range ports {
+		idx, ok := hosts[port.addr]
+		if !ok {
+			idx = len(run.Hosts)
+			hosts[port.addr] = idx
+			run.Hosts = append(run.Hosts, newHost(port.addr, Status{State: "up"}))
+		}
+
+		run.Hosts[idx].Ports = append(run.Hosts[idx].Ports, Port{
+			ID:       port.id,
+			Protocol: "tcp",
+			State:    State{State: string(port.state)},
+		})
+	}
+
+	return run
+}
+
+func TestDiffRuns(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous *Run
+		current  *Run
+		want     []PortTransition
+	}{
+		{
+			"unchanged",
+			newTestRun(testPort{"10.0.0.1", 22, Open}),
+			newTestRun(testPort{"10.0.0.1", 22, Open}),
+			nil,
+		},
+		{
+			"opened and closed",
+			newTestRun(testPort{"10.0.0.1", 22, Open}, testPort{"10.0.0.1", 80, Closed}),
+			newTestRun(testPort{"10.0.0.1", 80, Open}, testPort{"10.0.0.1", 443, Open}),
+			[]PortTransition{
+				{Address: "10.0.0.1", Port: 80, Protocol: "tcp", From: Closed, To: Open},
+				{Address: "10.0.0.1", Port: 443, Protocol: "tcp", From: Closed, To: Open},
+				{Address: "10.0.0.1", Port: 22, Protocol: "tcp", From: Open, To: Closed},
+			},
+		},
+		{
+			"host gone",
+			newTestRun(testPort{"10.0.0.1", 22, Open}, testPort{"10.0.0.2", 22, Filtered}),
+			newTestRun(testPort{"10.0.0.1", 22, Open}),
+			[]PortTransition{{Address: "10.0.0.2", Port: 22, Protocol: "tcp", From: Filtered, To: Closed}},
+		},
+		{
+			"no previous run",
+			nil,
+			newTestRun(testPort{"10.0.0.1", 22, Open}),
+			[]PortTransition{{Address: "10.0.0.1", Port: 22, Protocol: "tcp", From: Closed, To: Open}},
+		},
+		{
+			"no current run",
+			newTestRun(testPort{"10.0.0.1", 22, Open}, testPort{"10.0.0.1", 23, Closed}),
+			nil,
+			[]PortTransition{{Address: "10.0.0.1", Port: 22, Protocol: "tcp", From: Open, To: Closed}},
+		},
+	}
+
+	for _, test := range tests {
+		if got := DiffRuns(test.previous, test.current); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	run := func(reversed bool, elapsed float32, version string) *Run {
+		ports := []testPort{{"10.0.0.1", 22, Open}, {"10.0.0.1", 80, Open}, {"10.0.0.2", 443, Open}}
+		if reversed {
+			ports = []testPort{ports[2], ports[1], ports[0]}
+		}
+
+		r := newTestRun(ports...)
+		r.Start = Timestamp(time.Unix(1638862444+int64(elapsed), 0))
+		r.Stats.Finished.Elapsed = elapsed
+		for idx := range r.Hosts {
+			for port := range r.Hosts[idx].Ports {
+				r.Hosts[idx].Ports[port].Service = Service{Name: "svc", Version: version}
+			}
+		}
+		return r
+	}
+
+	base := run(false, 1, "1.0").Fingerprint()
+	if got := run(true, 42, "1.0").Fingerprint(); got != base {
+		t.Errorf("expected runs differing in order and timing to have the same fingerprint, got %s and %s", base, got)
+	}
+	if got := run(false, 1, "2.0").Fingerprint(); got == base {
+		t.Error("expected runs differing in service version to have different fingerprints")
+	}
+
+	closed := run(false, 1, "1.0")
+	closed.Hosts[0].Ports[0].State.State = string(Closed)
+	if closed.Fingerprint() == base {
+		t.Error("expected runs differing in port state to have different fingerprints")
+	}
+}
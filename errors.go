@@ -2,6 +2,9 @@ package RustScan
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 var (
@@ -10,17 +13,155 @@ var (
 	// the RustScan binary is present in the user's $PATH.
 	ErrRustScanNotInstalled = errors.New("RustScan binary was not found")
 
-	// ErrScanTimeout means that the provided context was done before the scanner finished its scan.
+	// ErrInvalidPort means that a port is not a number between 1 and 65535, or that a range
+	// of ports is malformed, such as one whose start is after its end.
+	ErrInvalidPort = errors.New("invalid port")
+
+	// ErrConfigNotFound means that the RustScan configuration file set with WithConfigPath
+	// does not exist.
+	ErrConfigNotFound = errors.New("RustScan configuration file was not found")
+
+	// ErrScanInProgress means that a scan was started while the scanner was running another
+	// one. A scanner can be reused once its scan ended, use a scanner per concurrent scan.
+	ErrScanInProgress = errors.New("a scan is already in progress")
+
+	// ErrNoActiveScan means that Scanner.Wait was called before the scanner started a scan.
+	ErrNoActiveScan = errors.New("no RustScan scan was started")
+
+	// ErrScanTimeout means that the provided context was done before the scanner finished its
+	// scan, see ScanTimeoutError.
 	ErrScanTimeout = errors.New("RustScan scan timed out")
 
+	// ErrScanCDN means that the scan reported more open ports than allowed by
+	// WithCDNPortLimit, see CDNError.
 	ErrScanCDN = errors.New("Suspected CDN, no scanning")
 
 	// ErrMallocFailed means that RustScan crashed due to insufficient memory, which may happen on large target networks.
 	ErrMallocFailed = errors.New("malloc failed, probably out of space")
 
+	// ErrPermissionDenied means that RustScan or nmap failed for lack of privileges, such as
+	// the root privileges needed to open raw sockets.
+	ErrPermissionDenied = errors.New("RustScan was denied the permissions it needs")
+
+	// ErrNoTargets means that there was nothing to scan, such as when none of the targets
+	// could be resolved.
+	ErrNoTargets = errors.New("RustScan had no target to scan")
+
+	// ErrRustScanPanic means that RustScan crashed, printing a panic or a backtrace.
+	ErrRustScanPanic = errors.New("RustScan panicked")
+
 	// ErrParseOutput means that RustScan's output was not parsed successfully.
 	ErrParseOutput = errors.New("unable to parse RustScan output, see warnings for details")
 
 	// ErrResolveName means that RustScan could not resolve a name.
 	ErrResolveName = errors.New("RustScan could not resolve a name")
+
+	// ErrNmapInterrupted means that nmap was interrupted by a signal before it finished.
+	ErrNmapInterrupted = errors.New("nmap was interrupted")
+
+	// ErrNmapFailed means that nmap reported an error that is not otherwise recognized in
+	// its XML output, such as a fatal error it quit on. The error includes nmap's message.
+	ErrNmapFailed = errors.New("nmap failed")
+
+	// ErrRetryBudgetExceeded means that the scan retried more than allowed by WithRetryBudget.
+	ErrRetryBudgetExceeded = errors.New("RustScan scan exceeded its retry budget")
+
+	// ErrOutputTooLarge means that RustScan wrote more output than allowed by WithStdoutMaxBytes.
+	ErrOutputTooLarge = errors.New("RustScan output exceeded the maximum size")
+
+	// ErrPrivilegesRequired means that the scan uses an nmap scan type that needs root
+	// privileges while the process is not privileged, see WithStrictPrivilegeCheck.
+	ErrPrivilegesRequired = errors.New("scan requires root privileges")
+
+	// ErrForbiddenTarget means that a target lies within a range forbidden with
+	// WithForbiddenCIDRs, or could not be checked against them.
+	ErrForbiddenTarget = errors.New("target is in a forbidden range")
+
+	// ErrOutsideScanWindow means that a scan was started outside the window set with
+	// WithAllowedWindow.
+	ErrOutsideScanWindow = errors.New("scan started outside the allowed window")
+
+	// ErrNoRawXML means that a Run does not hold the XML it was parsed from, see Run.WriteXML.
+	ErrNoRawXML = errors.New("no raw XML output")
 )
+
+// CDNError is the error of a scan stopped by WithCDNPortLimit. It matches ErrScanCDN
+// with errors.Is.
+type CDNError struct {
+	// OpenPorts is the number of open ports RustScan had reported when the scan was
+	// stopped. The scan is stopped as soon as the limit is exceeded, so the target may
+	// have more open ports.
+	OpenPorts int
+	// Limit is the limit set with WithCDNPortLimit.
+	Limit int
+}
+
+func (e *CDNError) Error() string {
+	return fmt.Sprintf("%v: %d open ports, limit %d", ErrScanCDN, e.OpenPorts, e.Limit)
+}
+
+// Unwrap returns ErrScanCDN.
+func (e *CDNError) Unwrap() error {
+	return ErrScanCDN
+}
+
+// ScanTimeoutError is the error of a scan stopped because its context was done. It
+// matches ErrScanTimeout with errors.Is.
+type ScanTimeoutError struct {
+	// Elapsed is how long the scan ran before it was stopped.
+	Elapsed time.Duration
+	// OpenPorts is the number of open ports RustScan had reported when the scan was
+	// stopped.
+	OpenPorts int
+}
+
+func (e *ScanTimeoutError) Error() string {
+	return fmt.Sprintf("%v after %v, %d open ports found", ErrScanTimeout, e.Elapsed, e.OpenPorts)
+}
+
+// Unwrap returns ErrScanTimeout.
+func (e *ScanTimeoutError) Unwrap() error {
+	return ErrScanTimeout
+}
+
+// ValidationErrors lists the problems found in the options of a scanner, see Scanner.Validate.
+type ValidationErrors []error
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, err.Error())
+	}
+
+	return "invalid scanner options: " + strings.Join(messages, "; ")
+}
+
+// Unwrap returns the individual errors, so that errors.Is and errors.As can match them
+// from Go 1.20 on.
+func (v ValidationErrors) Unwrap() []error {
+	return v
+}
+
+// Is reports whether one of the individual errors matches target, for errors.Is on
+// toolchains older than Go 1.20, which do not unwrap a list of errors.
+func (v ValidationErrors) Is(target error) bool {
+	for _, err := range v {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first individual error that matches target, for errors.As on toolchains
+// older than Go 1.20.
+func (v ValidationErrors) As(target interface{}) bool {
+	for _, err := range v {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
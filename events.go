@@ -0,0 +1,101 @@
+package RustScan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Enumerates the types of the events written by WithEventStreamJSON.
+const (
+	// EventScanStart is written when Run starts a scan.
+	EventScanStart = "scan_start"
+	// EventPortOpen is written for every open port RustScan reports, as it reports it.
+	EventPortOpen = "port_open"
+	// EventHostDone is written for every host of the result once nmap is done.
+	EventHostDone = "host_done"
+	// EventScanEnd is written when Run returns, with the error it returns if any.
+	EventScanEnd = "scan_end"
+)
+
+// ScanEvent is an event of the stream written by WithEventStreamJSON. Only the fields
+// relevant to its type are set.
+type ScanEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// ScanID identifies the scan, see WithScanID.
+	ScanID string `json:"scan_id"`
+	// Name is the label set with WithScanName.
+	Name string `json:"name,omitempty"`
+
+	// Address and Port identify the open port of a port_open event, and Address the host
+	// of a host_done event.
+	Address string `json:"address,omitempty"`
+	Port    uint16 `json:"port,omitempty"`
+	// State is the status of the host of a host_done event.
+	State string `json:"state,omitempty"`
+	// OpenPorts are the open ports of the host of a host_done event.
+	OpenPorts []uint16 `json:"open_ports,omitempty"`
+
+	// Hosts is the number of hosts of the result of a scan_end event.
+	Hosts int `json:"hosts,omitempty"`
+	// Error is the error a scan_end event reports.
+	Error string `json:"error,omitempty"`
+}
+
+// eventStream writes scan events as newline delimited JSON, and keeps the first error
+// so that a broken writer does not affect the scan. It is safe for concurrent use.
+type eventStream struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	scanID  string
+	name    string
+	err     error
+}
+
+func newEventStream(w io.Writer, scanID, name string) *eventStream {
+	return &eventStream{encoder: json.NewEncoder(w), scanID: scanID, name: name}
+}
+
+// emit writes an event, setting its time, scan ID and scan name. It does nothing on a
+// nil stream.
+func (e *eventStream) emit(event ScanEvent) {
+	if e == nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.err != nil {
+		return
+	}
+
+	event.Time = time.Now()
+	event.ScanID = e.scanID
+	event.Name = e.name
+
+	if err := e.encoder.Encode(event); err != nil {
+		e.err = fmt.Errorf("unable to write scan event: %w", err)
+	}
+}
+
+// hostsDone writes a host_done event for each host of the result.
+func (e *eventStream) hostsDone(result *Run) {
+	if e == nil || result == nil {
+		return
+	}
+
+	for _, host := range result.Hosts {
+		event := ScanEvent{Type: EventHostDone, Address: hostKey(host), State: host.Status.State}
+		for _, port := range host.Ports {
+			if port.State.State == string(Open) {
+				event.OpenPorts = append(event.OpenPorts, port.ID)
+			}
+		}
+
+		e.emit(event)
+	}
+}
@@ -0,0 +1,104 @@
+package RustScan
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeEvents decodes the newline delimited JSON events of a stream.
+func decodeEvents(t *testing.T, stream []byte) []ScanEvent {
+	t.Helper()
+
+	var events []ScanEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(stream)), "\n") {
+		var event ScanEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestWithEventStreamJSON(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	tests := []struct {
+		name    string
+		options []Option
+		types   []string
+		err     string
+	}{
+		{
+			"success",
+			nil,
+			[]string{EventScanStart, EventPortOpen, EventPortOpen, EventHostDone, EventScanEnd},
+			"",
+		},
+		{
+			"CDN",
+			[]Option{WithCDNPortLimit(1)},
+			[]string{EventScanStart, EventPortOpen, EventPortOpen, EventScanEnd},
+			ErrScanCDN.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		var stream bytes.Buffer
+		options := append([]Option{WithTargets("10.0.0.1"), WithPorts("22,80"), WithScanName("siem"), WithEventStreamJSON(&stream)}, test.options...)
+		_, _, _ = newFakeScanner(t, options...).Run()
+
+		events := decodeEvents(t, stream.Bytes())
+
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type)
+			if event.Time.IsZero() || event.ScanID == "" || event.ScanID != events[0].ScanID || event.Name != "siem" {
+				t.Errorf("%s: expected the time, scan ID and name on every event, got %+v", test.name, event)
+			}
+		}
+		if !reflect.DeepEqual(types, test.types) {
+			t.Errorf("%s: expected events %v, got %v", test.name, test.types, types)
+			continue
+		}
+
+		if open := events[1]; open.Address != "10.0.0.1" || open.Port != 22 {
+			t.Errorf("%s: expected port 10.0.0.1:22 to open first, got %+v", test.name, open)
+		}
+
+		end := events[len(events)-1]
+		if !strings.Contains(end.Error, test.err) || (test.err == "") != (end.Error == "") {
+			t.Errorf("%s: expected the scan to end with %q, got %q", test.name, test.err, end.Error)
+		}
+		if test.err == "" {
+			if done := events[3]; done.State != "up" || !reflect.DeepEqual(done.OpenPorts, []uint16{22, 80}) || end.Hosts != 1 {
+				t.Errorf("%s: unexpected host_done %+v and scan_end %+v", test.name, done, end)
+			}
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWithEventStreamJSONWriteError(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// A broken writer does not fail the scan, its error is returned among the warnings.
+	result, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithEventStreamJSON(failingWriter{})).Run()
+	if err != nil || len(result.Hosts) != 1 {
+		t.Fatalf("unexpected result %+v and error %v", result, err)
+	}
+
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "disk full") {
+		t.Errorf("expected the write error among the warnings, got %v", warnings)
+	}
+}
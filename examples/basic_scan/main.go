@@ -16,19 +16,19 @@ func main() {
 	// with a 5 minute timeout.
 	scanner, err := RustScan.NewScanner(
 		RustScan.WithTargets("baidu.com"),
-		RustScan.WithPorts("1-65535"),
+		RustScan.WithRange(1, 65535),
 		RustScan.WithContext(ctx),
 		RustScan.WithBatchSize(4500),
 		RustScan.WithTimeout(1500),
 		RustScan.WithScanOrder("random"),
 		RustScan.WithUlimit(5000),
+		RustScan.WithCDNPortLimit(30),
 	)
 	if err != nil {
 		log.Fatalf("unable to create RustScan scanner: %v", err)
 	}
 
-	// 传入一个 limit ，如果 rustscan 先扫出的端口大于 传入的值，则判断存在 cdn，就不在调用 nmap 识别
-	result, _, err := scanner.Run(30)
+	result, _, err := scanner.Run()
 	if err != nil {
 		log.Fatalf("unable to run RustScan scan: %v", err)
 	}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/yhy0/RustScan"
+	"log"
+	"sync"
+	"time"
+)
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	scanner, err := RustScan.NewScanner(
+		RustScan.WithTargets("baidu.com"),
+		RustScan.WithRange(1, 65535),
+		RustScan.WithContext(ctx),
+		RustScan.WithCDNPortLimit(30),
+		RustScan.WithServiceInfo(),
+	)
+	if err != nil {
+		log.Fatalf("unable to create RustScan scanner: %v", err)
+	}
+
+	progress := make(chan float32, 1)
+
+	// The channel is closed when the scan is done, wait for the last values to be
+	// printed before the results.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for value := range progress {
+			fmt.Printf("Progress: %.0f%%\n", value*100)
+		}
+	}()
+
+	result, _, err := scanner.RunWithProgress(progress)
+	wg.Wait()
+	if err != nil {
+		log.Fatalf("unable to run RustScan scan: %v", err)
+	}
+
+	for _, host := range result.Hosts {
+		if len(host.Ports) == 0 || len(host.Addresses) == 0 {
+			continue
+		}
+
+		fmt.Printf("Host %q:\n", host.Addresses[0])
+
+		for _, port := range host.Ports {
+			fmt.Printf("\tPort %d/%s %s %s\n", port.ID, port.Protocol, port.State, port.Service.Name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package RustScan
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// hostnamePattern matches the hostnames nmap accepts as targets.
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+
+// excludedAddresses are the addresses excluded from a scan with WithExcludeAddresses.
+type excludedAddresses struct {
+	specs     []string
+	networks  []*net.IPNet
+	hostnames []string
+}
+
+// add adds an IP address, CIDR or hostname to the excluded addresses.
+func (e *excludedAddresses) add(addr string) error {
+	addr = strings.TrimSpace(addr)
+
+	if network := parseNetwork(addr); network != nil {
+		e.networks = append(e.networks, network)
+	} else if hostnamePattern.MatchString(addr) {
+		e.hostnames = append(e.hostnames, strings.ToLower(strings.TrimSuffix(addr, ".")))
+	} else {
+		return fmt.Errorf("invalid excluded address %q", addr)
+	}
+
+	e.specs = append(e.specs, addr)
+
+	return nil
+}
+
+// contains reports whether a host has one of the excluded addresses or hostnames.
+func (e *excludedAddresses) contains(host Host) bool {
+	for _, address := range host.Addresses {
+		if ip := net.ParseIP(address.Addr); ip != nil {
+			for _, network := range e.networks {
+				if network.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, hostname := range host.Hostnames {
+		name := strings.ToLower(strings.TrimSuffix(hostname.Name, "."))
+		for _, excluded := range e.hostnames {
+			if name == excluded {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// filterTargets removes the excluded addresses from the addresses of the RustScan
+// arguments, so that its port scan does not reach them either. The rest of a CIDR
+// target is scanned as smaller CIDRs. Hostnames are not resolved, they are only removed
+// when excluded by name.
+func (e *excludedAddresses) filterTargets(args []string) ([]string, error) {
+	if len(e.specs) == 0 {
+		return args, nil
+	}
+
+	targets, rest, at := extractTargets(args)
+	if at < 0 {
+		return args, nil
+	}
+
+	var kept []string
+	for _, target := range targets {
+		kept = append(kept, e.remaining(target)...)
+	}
+
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("every target is excluded with WithExcludeAddresses")
+	}
+
+	return insertTargets(rest, at, kept), nil
+}
+
+// remaining returns what is left of a target once the excluded addresses are removed.
+func (e *excludedAddresses) remaining(target string) []string {
+	if _, network, err := net.ParseCIDR(target); err == nil {
+		parts := []*net.IPNet{network}
+		for _, excluded := range e.networks {
+			var next []*net.IPNet
+			for _, part := range parts {
+				next = append(next, subtractNetwork(part, excluded)...)
+			}
+			parts = next
+		}
+
+		if len(parts) == 1 && parts[0].String() == network.String() {
+			return []string{target}
+		}
+
+		remaining := make([]string, 0, len(parts))
+		for _, part := range parts {
+			remaining = append(remaining, part.String())
+		}
+
+		return remaining
+	}
+
+	host := Host{Addresses: []Address{{Addr: target}}, Hostnames: []Hostname{{Name: target}}}
+	if e.contains(host) {
+		return nil
+	}
+
+	return []string{target}
+}
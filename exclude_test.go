@@ -0,0 +1,118 @@
+package RustScan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExcludedAddressesFilterTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		excluded []string
+		targets  string
+		want     string
+	}{
+		{"single address", []string{"10.0.0.2"}, "10.0.0.1,10.0.0.2,10.0.0.3", "10.0.0.1,10.0.0.3"},
+		{"network", []string{"10.0.0.0/30"}, "10.0.0.1,10.0.0.5", "10.0.0.5"},
+		{"split CIDR", []string{"10.0.0.0/26"}, "10.0.0.0/24", "10.0.0.64/26,10.0.0.128/25"},
+		{"untouched CIDR", []string{"192.168.0.1"}, "10.0.0.0/24", "10.0.0.0/24"},
+		{"hostname", []string{"Gateway.example.com."}, "gateway.example.com,www.example.com", "www.example.com"},
+		{"unresolved hostname", []string{"10.0.0.1"}, "gateway.example.com", "gateway.example.com"},
+	}
+
+	for _, test := range tests {
+		var excluded excludedAddresses
+		for _, addr := range test.excluded {
+			if err := excluded.add(addr); err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+		}
+
+		args, err := excluded.filterTargets([]string{"-a", test.targets, "-p", "22"})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if want := []string{"-a", test.want, "-p", "22"}; !reflect.DeepEqual(args, want) {
+			t.Errorf("%s: expected %v, got %v", test.name, want, args)
+		}
+	}
+
+	var excluded excludedAddresses
+	_ = excluded.add("10.0.0.0/24")
+	if _, err := excluded.filterTargets([]string{"-a", "10.0.0.1,10.0.0.0/25"}); err == nil {
+		t.Error("expected an error when every target is excluded")
+	}
+}
+
+func TestExcludedAddressesContains(t *testing.T) {
+	var excluded excludedAddresses
+	for _, addr := range []string{"10.0.0.0/30", "2001:db8::1", "gateway.example.com"} {
+		if err := excluded.add(addr); err != nil {
+			t.Fatalf("%s: unexpected error: %v", addr, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		host Host
+		want bool
+	}{
+		{"address in network", Host{Addresses: []Address{{Addr: "10.0.0.3"}}}, true},
+		{"address outside network", Host{Addresses: []Address{{Addr: "10.0.0.4"}}}, false},
+		{"IPv6 address", Host{Addresses: []Address{{Addr: "2001:db8::1"}}}, true},
+		{"MAC address", Host{Addresses: []Address{{Addr: "00:11:22:33:44:55"}}}, false},
+		{"hostname", Host{Addresses: []Address{{Addr: "10.0.0.9"}}, Hostnames: []Hostname{{Name: "GATEWAY.example.com."}}}, true},
+		{"other hostname", Host{Addresses: []Address{{Addr: "10.0.0.9"}}, Hostnames: []Hostname{{Name: "www.example.com"}}}, false},
+	}
+
+	for _, test := range tests {
+		if got := excluded.contains(test.host); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestWithExcludeAddresses(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.0/24"), WithExcludeAddresses("10.0.0.0/25", "gateway.example.com"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The addresses are kept out of the port scan and of the nmap stage.
+	want := [][]string{{"-a", "10.0.0.128/25", "--", "--exclude", "10.0.0.0/25,gateway.example.com", "-oX", "-"}}
+	if !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	for _, addr := range []string{"", "10.0.0.0/33", "not a host!"} {
+		if _, err := NewScanner(WithExcludeAddresses(addr)); err == nil || !strings.Contains(err.Error(), "invalid excluded address") {
+			t.Errorf("%q: expected an invalid excluded address error, got %v", addr, err)
+		}
+	}
+}
+
+func TestWithExcludeAddressesResult(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_OUTPUT_FILE": "testdata/merge1.xml"})()
+
+	// nmap's output naming an excluded host, as RustScan alone would not leave it out.
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.0/24"), WithExcludeAddresses("10.0.0.2")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var addrs []string
+	for _, host := range result.Hosts {
+		addrs = append(addrs, host.Addresses[0].Addr)
+	}
+	if want := []string{"10.0.0.1"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("expected hosts %v, got %v", want, addrs)
+	}
+}
@@ -0,0 +1,150 @@
+package RustScan
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The parsers of this file are the ones of WithFastParse. They give the same results as
+// parseOpenLine and parseGreppableLine, but scan the bytes of a line themselves rather
+// than going through a regular expression and strings.Split, so that the only allocation
+// for a line is the address of the host.
+
+// lineStorage is the size of the buffer on the stack a line is copied to, longer lines
+// are copied to the heap.
+const lineStorage = 1024
+
+// parseOpenLineFast is parseOpenLine without the allocations, see WithFastParse.
+func parseOpenLineFast(line string) (openPort, bool) {
+	var storage [lineStorage]byte
+	b := bytes.TrimSpace(appendVisible(storage[:0], line))
+
+	const prefix = "Open "
+	if len(b) < len(prefix) || string(b[:len(prefix)]) != prefix {
+		return openPort{}, false
+	}
+
+	host, port, ok := splitHostPortBytes(bytes.TrimSpace(b[len(prefix):]))
+	if !ok {
+		return openPort{}, false
+	}
+
+	id, ok := parsePortBytes(port)
+	if !ok {
+		return openPort{}, false
+	}
+
+	return openPort{addr: string(host), port: id}, true
+}
+
+// parseGreppableLineFast is parseGreppableLine without the allocations, see
+// WithFastParse. It appends the ports of the line to dst, so that a buffer can be
+// reused from one line to the next.
+func parseGreppableLineFast(dst []openPort, line string) ([]openPort, bool, error) {
+	var storage [lineStorage]byte
+	b := bytes.TrimSpace(appendVisible(storage[:0], line))
+
+	const sep = " -> ["
+	at := bytes.Index(b, []byte(sep))
+	if at < 0 || b[len(b)-1] != ']' {
+		return dst, false, nil
+	}
+
+	addr := string(bytes.TrimSpace(b[:at]))
+	list := b[at+len(sep) : len(b)-1]
+
+	for len(list) > 0 {
+		elem := list
+		if comma := bytes.IndexByte(list, ','); comma >= 0 {
+			elem, list = list[:comma], list[comma+1:]
+		} else {
+			list = nil
+		}
+
+		elem = bytes.TrimSpace(elem)
+		if len(elem) == 0 {
+			continue
+		}
+
+		id, ok := parsePortBytes(elem)
+		if !ok || id == 0 {
+			return dst, true, fmt.Errorf("invalid port %q for %s", string(elem), addr)
+		}
+
+		dst = append(dst, openPort{addr: addr, port: id})
+	}
+
+	return dst, true, nil
+}
+
+// appendVisible appends the bytes of line that are not part of an ANSI color escape
+// code to dst, see stripANSI.
+func appendVisible(dst []byte, line string) []byte {
+	for i := 0; i < len(line); i++ {
+		if line[i] == 0x1b && i+1 < len(line) && line[i+1] == '[' {
+			j := i + 2
+			for j < len(line) && (line[j] >= '0' && line[j] <= '9' || line[j] == ';') {
+				j++
+			}
+			if j < len(line) && line[j] == 'm' {
+				i = j
+				continue
+			}
+		}
+
+		dst = append(dst, line[i])
+	}
+
+	return dst
+}
+
+// splitHostPortBytes splits "host:port" or "[host]:port" like net.SplitHostPort.
+func splitHostPortBytes(b []byte) (host, port []byte, ok bool) {
+	colon := bytes.LastIndexByte(b, ':')
+	if colon < 0 {
+		return nil, nil, false
+	}
+
+	// The host may only contain brackets around an address with colons, such as IPv6.
+	start, end := 0, 0
+	if len(b) > 0 && b[0] == '[' {
+		closing := bytes.IndexByte(b, ']')
+		if closing < 0 || closing+1 != colon {
+			return nil, nil, false
+		}
+		host = b[1:closing]
+		start, end = 1, closing+1
+	} else {
+		host = b[:colon]
+		if bytes.IndexByte(host, ':') >= 0 {
+			return nil, nil, false
+		}
+	}
+
+	if bytes.IndexByte(b[start:], '[') >= 0 || bytes.IndexByte(b[end:], ']') >= 0 {
+		return nil, nil, false
+	}
+
+	return host, b[colon+1:], true
+}
+
+// parsePortBytes parses a port number like strconv.ParseUint(s, 10, 16).
+func parsePortBytes(b []byte) (uint16, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var value uint32
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+
+		value = value*10 + uint32(c-'0')
+		if value > 65535 {
+			return 0, false
+		}
+	}
+
+	return uint16(value), true
+}
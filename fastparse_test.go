@@ -0,0 +1,143 @@
+package RustScan
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var openLines = []string{
+	"Open 192.168.1.1:80",
+	"Open \x1b[35m192.168.1.1:443\x1b[0m",
+	"  Open 10.0.0.1:22  ",
+	"Open [::1]:8080",
+	"Open \x1b[35m[fe80::1]:22\x1b[0m",
+	"Open example.com:80",
+	"Open 10.0.0.1:0",
+	"Open 10.0.0.1:65535",
+	"Open 10.0.0.1:65536",
+	"Open 10.0.0.1:",
+	"Open 10.0.0.1",
+	"Open ::1:80",
+	"Open [::1:80",
+	"Open [::1]]:80",
+	"Open 10.0.0.1:8a",
+	"Open :80",
+	"Opened 10.0.0.1:80",
+	"open 10.0.0.1:80",
+	"[~] Starting Script(s)",
+	"",
+	"\x1b[1m",
+}
+
+var greppableLines = []string{
+	"192.168.1.1 -> [22,80]",
+	"\x1b[35m192.168.1.1\x1b[0m -> [22, 80 ,443]",
+	"10.0.0.1 -> []",
+	"10.0.0.1 -> [22,,80]",
+	"::1 -> [8080]",
+	"10.0.0.1 -> [0]",
+	"10.0.0.1 -> [70000]",
+	"10.0.0.1 -> [http]",
+	"10.0.0.1 -> [22",
+	"10.0.0.1 [22]",
+	"Open 10.0.0.1:22",
+	"",
+}
+
+func TestParseOpenLineFast(t *testing.T) {
+	for _, line := range openLines {
+		want, wantOK := parseOpenLine(line)
+		got, gotOK := parseOpenLineFast(line)
+
+		if got != want || gotOK != wantOK {
+			t.Errorf("%q: expected %v %v, got %v %v", line, want, wantOK, got, gotOK)
+		}
+	}
+}
+
+func TestParseGreppableLineFast(t *testing.T) {
+	for _, line := range greppableLines {
+		want, wantOK, wantErr := parseGreppableLine(line)
+		got, gotOK, gotErr := parseGreppableLineFast(nil, line)
+
+		if !reflect.DeepEqual(got, want) || gotOK != wantOK || fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+			t.Errorf("%q: expected %v %v %v, got %v %v %v", line, want, wantOK, wantErr, got, gotOK, gotErr)
+		}
+	}
+}
+
+func TestParseGreppableLineFastAppends(t *testing.T) {
+	dst := []openPort{{addr: "10.0.0.1", port: 1}}
+
+	got, ok, err := parseGreppableLineFast(dst, "10.0.0.2 -> [22]")
+	if !ok || err != nil {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+
+	want := []openPort{{addr: "10.0.0.1", port: 1}, {addr: "10.0.0.2", port: 22}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWithFastParse(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	for _, fast := range []bool{false, true} {
+		options := []Option{WithTargets("10.0.0.1"), WithPorts("22,80"), WithScripts(ScriptsNone)}
+		if fast {
+			options = append(options, WithFastParse())
+		}
+
+		result, _, err := newFakeScanner(t, options...).Run()
+		if err != nil {
+			t.Fatalf("fast %v: unexpected error: %v", fast, err)
+		}
+
+		if len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 2 {
+			t.Errorf("fast %v: expected 1 host with 2 ports, got %+v", fast, result.Hosts)
+		}
+	}
+}
+
+// benchmarkGreppableLine has the open ports of a host scanned on all its ports.
+var benchmarkGreppableLine = func() string {
+	ports := make([]string, 0, 40)
+	for port := 1; port <= 40; port++ {
+		ports = append(ports, fmt.Sprint(port*1000))
+	}
+
+	return "\x1b[35m192.168.100.200\x1b[0m -> [" + strings.Join(ports, ",") + "]"
+}()
+
+func BenchmarkParseOpenLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseOpenLine("Open \x1b[35m192.168.100.200:8443\x1b[0m")
+	}
+}
+
+func BenchmarkParseOpenLineFast(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseOpenLineFast("Open \x1b[35m192.168.100.200:8443\x1b[0m")
+	}
+}
+
+func BenchmarkParseGreppableLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = parseGreppableLine(benchmarkGreppableLine)
+	}
+}
+
+func BenchmarkParseGreppableLineFast(b *testing.B) {
+	b.ReportAllocs()
+
+	var ports []openPort
+	for i := 0; i < b.N; i++ {
+		ports, _, _ = parseGreppableLineFast(ports[:0], benchmarkGreppableLine)
+	}
+}
@@ -0,0 +1,76 @@
+package RustScan
+
+// FilterChain is an ordered list of filters applied to the ports and hosts of a result,
+// see WithFilterChain. Each stage sees the result of the previous ones.
+type FilterChain struct {
+	stages      []filterStage
+	recordStats bool
+}
+
+type filterStage struct {
+	name string
+	port func(Port) bool
+	host func(Host) bool
+}
+
+// FilterStat is the number of ports and hosts removed by a stage of a FilterChain.
+type FilterStat struct {
+	Stage        string `json:"stage"`
+	PortsRemoved int    `json:"ports_removed"`
+	HostsRemoved int    `json:"hosts_removed"`
+}
+
+// NewFilterChain creates an empty filter chain.
+func NewFilterChain() *FilterChain {
+	return &FilterChain{}
+}
+
+// FilterPorts adds a stage keeping the ports for which filter returns true.
+func (c *FilterChain) FilterPorts(name string, filter func(Port) bool) *FilterChain {
+	c.stages = append(c.stages, filterStage{name: name, port: filter})
+	return c
+}
+
+// FilterHosts adds a stage keeping the hosts for which filter returns true.
+func (c *FilterChain) FilterHosts(name string, filter func(Host) bool) *FilterChain {
+	c.stages = append(c.stages, filterStage{name: name, host: filter})
+	return c
+}
+
+// RecordStats makes the chain record how many ports and hosts each stage removed in
+// Run.FilterStats.
+func (c *FilterChain) RecordStats() *FilterChain {
+	c.recordStats = true
+	return c
+}
+
+// Apply runs the stages of the chain in order on the result.
+func (c *FilterChain) Apply(result *Run) {
+	for _, stage := range c.stages {
+		ports, hosts := countPorts(result), len(result.Hosts)
+
+		if stage.port != nil {
+			choosePorts(result, stage.port)
+		}
+		if stage.host != nil {
+			chooseHosts(result, stage.host)
+		}
+
+		if c.recordStats {
+			result.FilterStats = append(result.FilterStats, FilterStat{
+				Stage:        stage.name,
+				PortsRemoved: ports - countPorts(result),
+				HostsRemoved: hosts - len(result.Hosts),
+			})
+		}
+	}
+}
+
+func countPorts(result *Run) int {
+	count := 0
+	for _, host := range result.Hosts {
+		count += len(host.Ports)
+	}
+
+	return count
+}
@@ -0,0 +1,76 @@
+package RustScan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterChain(t *testing.T) {
+	noHTTPS := func(port Port) bool { return port.ID != 443 }
+	withPorts := func(host Host) bool { return len(host.Ports) > 0 }
+
+	tests := []struct {
+		name  string
+		chain *FilterChain
+		hosts map[string]int
+		stats []FilterStat
+	}{
+		{
+			"ports then hosts",
+			NewFilterChain().FilterPorts("no https", noHTTPS).FilterHosts("with ports", withPorts).RecordStats(),
+			map[string]int{"10.0.0.1": 2},
+			[]FilterStat{{Stage: "no https", PortsRemoved: 2}, {Stage: "with ports", HostsRemoved: 2}},
+		},
+		{
+			// Each stage sees the result of the previous ones, so the order matters.
+			"hosts then ports",
+			NewFilterChain().FilterHosts("with ports", withPorts).FilterPorts("no https", noHTTPS).RecordStats(),
+			map[string]int{"10.0.0.1": 2, "10.0.0.3": 0},
+			[]FilterStat{{Stage: "with ports", HostsRemoved: 1}, {Stage: "no https", PortsRemoved: 2}},
+		},
+		{
+			"without stats",
+			NewFilterChain().FilterPorts("no https", noHTTPS),
+			map[string]int{"10.0.0.1": 2, "10.0.0.2": 0, "10.0.0.3": 0},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		run, err := ParseFiles("testdata/merge1.xml", "testdata/merge2.xml")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		test.chain.Apply(run)
+
+		hosts := make(map[string]int)
+		for _, host := range run.Hosts {
+			hosts[host.Addresses[0].Addr] = len(host.Ports)
+		}
+		if !reflect.DeepEqual(hosts, test.hosts) {
+			t.Errorf("%s: expected hosts %v, got %v", test.name, test.hosts, hosts)
+		}
+		if !reflect.DeepEqual(run.FilterStats, test.stats) {
+			t.Errorf("%s: expected stats %+v, got %+v", test.name, test.stats, run.FilterStats)
+		}
+	}
+}
+
+func TestWithFilterChain(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	chain := NewFilterChain().FilterPorts("ssh only", func(port Port) bool { return port.ID == 22 }).RecordStats()
+
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80,443"), WithFilterChain(chain)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if countPorts(result) != 2 {
+		t.Errorf("expected the 2 ssh ports, got %d ports", countPorts(result))
+	}
+	if want := []FilterStat{{Stage: "ssh only", PortsRemoved: 4}}; !reflect.DeepEqual(result.FilterStats, want) {
+		t.Errorf("expected stats %+v, got %+v", want, result.FilterStats)
+	}
+}
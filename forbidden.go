@@ -0,0 +1,167 @@
+package RustScan
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// parseForbiddenRange parses a range given to WithForbiddenCIDRs, a CIDR or a single IP.
+func parseForbiddenRange(value string) (*net.IPNet, error) {
+	network := parseNetwork(value)
+	if network == nil {
+		return nil, fmt.Errorf("invalid forbidden range %q", value)
+	}
+
+	return network, nil
+}
+
+// parseNetwork parses a CIDR or a single IP, which is a network of one address. It
+// returns nil for anything else.
+func parseNetwork(value string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// filterForbidden removes the ranges forbidden with WithForbiddenCIDRs from the
+// addresses of the RustScan arguments.
+func (s *Scanner) filterForbidden(args []string) ([]string, error) {
+	if len(s.forbidden) == 0 {
+		return args, nil
+	}
+
+	targets, rest, at := extractTargets(args)
+	if at < 0 {
+		return args, nil
+	}
+
+	var allowed []string
+	for _, target := range targets {
+		kept, err := s.allowedTargets(target)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed = append(allowed, kept...)
+	}
+
+	return insertTargets(rest, at, allowed), nil
+}
+
+// allowedTargets returns what may be scanned of a target: the target itself when it
+// does not overlap any forbidden range, the rest of a CIDR that partially overlaps one.
+// A target entirely within a forbidden range is an error, and so is a hostname resolving
+// to a forbidden address or that cannot be resolved.
+func (s *Scanner) allowedTargets(target string) ([]string, error) {
+	if _, network, err := net.ParseCIDR(target); err == nil {
+		remaining := []*net.IPNet{network}
+		for _, forbidden := range s.forbidden {
+			var next []*net.IPNet
+			for _, part := range remaining {
+				next = append(next, subtractNetwork(part, forbidden)...)
+			}
+			remaining = next
+		}
+
+		if len(remaining) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrForbiddenTarget, target)
+		}
+
+		if len(remaining) == 1 && remaining[0].String() == network.String() {
+			return []string{target}, nil
+		}
+
+		parts := make([]string, 0, len(remaining))
+		for _, part := range remaining {
+			parts = append(parts, part.String())
+		}
+
+		return parts, nil
+	}
+
+	if ip := net.ParseIP(target); ip != nil {
+		if s.isForbidden(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrForbiddenTarget, target)
+		}
+
+		return []string{target}, nil
+	}
+
+	// A hostname is scanned at the addresses it resolves to, none of which may be
+	// forbidden. It cannot be checked if it does not resolve, so it is rejected.
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to resolve %s to check it: %v", ErrForbiddenTarget, target, err)
+	}
+
+	for _, addr := range addrs {
+		if s.isForbidden(addr.IP) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrForbiddenTarget, target, addr.IP)
+		}
+	}
+
+	return []string{target}, nil
+}
+
+func (s *Scanner) isForbidden(ip net.IP) bool {
+	for _, forbidden := range s.forbidden {
+		if forbidden.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subtractNetwork returns the parts of network that are not in forbidden, as a list of
+// CIDRs: network itself when they do not overlap, nothing when forbidden contains it,
+// and the halves of network left once forbidden is removed when network contains it.
+func subtractNetwork(network, forbidden *net.IPNet) []*net.IPNet {
+	if len(network.IP) != len(forbidden.IP) {
+		return []*net.IPNet{network}
+	}
+
+	ones, bits := network.Mask.Size()
+	forbiddenOnes, _ := forbidden.Mask.Size()
+
+	switch {
+	case forbiddenOnes <= ones && forbidden.Contains(network.IP):
+		return nil
+	case ones < forbiddenOnes && network.Contains(forbidden.IP):
+		low, high := splitNetwork(network, ones, bits)
+		return append(subtractNetwork(low, forbidden), subtractNetwork(high, forbidden)...)
+	default:
+		return []*net.IPNet{network}
+	}
+}
+
+// splitNetwork splits a network into its lower and upper halves.
+func splitNetwork(network *net.IPNet, ones, bits int) (low, high *net.IPNet) {
+	mask := net.CIDRMask(ones+1, bits)
+
+	lowIP := make(net.IP, len(network.IP))
+	copy(lowIP, network.IP)
+
+	highIP := make(net.IP, len(network.IP))
+	copy(highIP, network.IP)
+	highIP[ones/8] |= 0x80 >> uint(ones%8)
+
+	return &net.IPNet{IP: lowIP, Mask: mask}, &net.IPNet{IP: highIP, Mask: mask}
+}
@@ -0,0 +1,97 @@
+package RustScan
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSubtractNetwork(t *testing.T) {
+	tests := []struct {
+		name      string
+		network   string
+		forbidden string
+		want      []string
+	}{
+		{"disjoint", "10.0.0.0/24", "10.0.1.0/24", []string{"10.0.0.0/24"}},
+		{"contained", "10.0.0.0/25", "10.0.0.0/24", nil},
+		{"equal", "10.0.0.0/24", "10.0.0.0/24", nil},
+		{"lower half", "10.0.0.0/24", "10.0.0.0/25", []string{"10.0.0.128/25"}},
+		{"single address", "10.0.0.0/30", "10.0.0.2/32", []string{"10.0.0.0/31", "10.0.0.3/32"}},
+		{"IPv6", "2001:db8::/126", "2001:db8::/127", []string{"2001:db8::2/127"}},
+		{"other family", "10.0.0.0/24", "2001:db8::/32", []string{"10.0.0.0/24"}},
+	}
+
+	for _, test := range tests {
+		network, forbidden := parseNetwork(test.network), parseNetwork(test.forbidden)
+
+		var got []string
+		for _, part := range subtractNetwork(network, forbidden) {
+			got = append(got, part.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestWithForbiddenCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    []string
+		err     bool
+	}{
+		{"allowed", []string{"10.0.0.1", "192.168.0.0/24"}, []string{"-a", "10.0.0.1,192.168.0.0/24"}, false},
+		{"partial overlap", []string{"10.1.0.0/23"}, []string{"-a", "10.1.1.0/24"}, false},
+		{"forbidden address", []string{"10.0.0.1", "10.1.0.7"}, nil, true},
+		{"forbidden CIDR", []string{"10.1.0.0/25"}, nil, true},
+		{"hostname resolving to a forbidden address", []string{"localhost"}, nil, true},
+	}
+
+	for _, test := range tests {
+		scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets(test.targets...), WithForbiddenCIDRs("10.1.0.0/24", "127.0.0.1", "::1"))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		got, err := scanner.filterForbidden(scanner.args)
+		if test.err {
+			if !errors.Is(err, ErrForbiddenTarget) {
+				t.Errorf("%s: expected ErrForbiddenTarget, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	if _, err := NewScanner(WithForbiddenCIDRs("10.0.0.0/33")); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"10.0.0.1", "10.0.0.1/32"},
+		{"10.0.0.1/8", "10.0.0.0/8"},
+		{"::1", "::1/128"},
+		{"example.com", "<nil>"},
+	}
+
+	for _, test := range tests {
+		got := parseNetwork(test.value)
+		if got.String() != test.want {
+			t.Errorf("%q: expected %s, got %s", test.value, test.want, got)
+		}
+	}
+}
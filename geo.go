@@ -0,0 +1,62 @@
+package RustScan
+
+import (
+	"fmt"
+	"net"
+)
+
+// GeoInfo is the location and network information of an IP address.
+type GeoInfo struct {
+	Country      string `json:"country,omitempty"`
+	CountryCode  string `json:"country_code,omitempty"`
+	City         string `json:"city,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// GeoIPProvider looks up the location of IP addresses, for instance in a local GeoIP
+// database. Any provider can be used with WithGeoIP, which keeps this package free of
+// a dependency on a specific database.
+type GeoIPProvider interface {
+	Lookup(ip net.IP) (GeoInfo, error)
+}
+
+// enrichGeo sets the Geo field of every host with an IP address, and returns a warning
+// for every failed lookup.
+func enrichGeo(result *Run, provider GeoIPProvider) []string {
+	var warnings []string
+
+	for idx := range result.Hosts {
+		host := &result.Hosts[idx]
+
+		ip := hostIP(*host)
+		if ip == nil {
+			continue
+		}
+
+		info, err := provider.Lookup(ip)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to look up the location of %s: %v", ip, err))
+			continue
+		}
+
+		host.Geo = &info
+	}
+
+	return warnings
+}
+
+// hostIP returns the first IP address of a host, or nil if it has none.
+func hostIP(host Host) net.IP {
+	for _, address := range host.Addresses {
+		if address.AddrType != "ipv4" && address.AddrType != "ipv6" {
+			continue
+		}
+
+		if ip := net.ParseIP(address.Addr); ip != nil {
+			return ip
+		}
+	}
+
+	return nil
+}
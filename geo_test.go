@@ -0,0 +1,62 @@
+package RustScan
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeGeoIP looks up the addresses of a map, and fails for the others.
+type fakeGeoIP map[string]GeoInfo
+
+func (f fakeGeoIP) Lookup(ip net.IP) (GeoInfo, error) {
+	info, ok := f[ip.String()]
+	if !ok {
+		return GeoInfo{}, errors.New("not found")
+	}
+
+	return info, nil
+}
+
+func TestWithGeoIP(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	provider := fakeGeoIP{"10.0.0.1": {Country: "France", CountryCode: "FR", ASN: 3215}}
+
+	result, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"), WithGeoIP(provider)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if geo := result.Hosts[0].Geo; geo == nil || !reflect.DeepEqual(*geo, provider["10.0.0.1"]) {
+		t.Errorf("expected the location of 10.0.0.1, got %+v", geo)
+	}
+
+	// A failed lookup leaves the host without location, and is reported.
+	if geo := result.Hosts[1].Geo; geo != nil {
+		t.Errorf("expected no location for 10.0.0.2, got %+v", geo)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "10.0.0.2") {
+		t.Errorf("expected a warning for 10.0.0.2, got %v", warnings)
+	}
+}
+
+func TestHostIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []Address
+		want      string
+	}{
+		{"IPv4", []Address{{Addr: "10.0.0.1", AddrType: "ipv4"}}, "10.0.0.1"},
+		{"MAC first", []Address{{Addr: "00:11:22:33:44:55", AddrType: "mac"}, {Addr: "::1", AddrType: "ipv6"}}, "::1"},
+		{"no IP", []Address{{Addr: "00:11:22:33:44:55", AddrType: "mac"}}, "<nil>"},
+	}
+
+	for _, test := range tests {
+		if got := hostIP(Host{Addresses: test.addresses}).String(); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package RustScan
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Enumerates the kinds of the nodes of a Graph.
+const (
+	GraphNodeHost    = "host"
+	GraphNodePort    = "port"
+	GraphNodeService = "service"
+)
+
+// Graph is a network map of a run: hosts linked to their open ports, themselves linked
+// to the service running on them.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a host, an open port of a host or a service. Services are shared by all
+// the ports they run on.
+type GraphNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// GraphEdge links a host to one of its open ports, or an open port to its service.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ToGraph builds the network map of the run, with a node for every host that is not
+// down, every open port of these hosts and every service found on them.
+func (r *Run) ToGraph() Graph {
+	var graph Graph
+	services := make(map[string]bool)
+
+	for _, host := range r.Hosts {
+		if host.Status.State == "down" {
+			continue
+		}
+
+		address := hostKey(host)
+		hostID := "host:" + address
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: hostID, Kind: GraphNodeHost, Label: address})
+
+		for _, port := range host.Ports {
+			if port.State.State != string(Open) {
+				continue
+			}
+
+			portLabel := fmt.Sprintf("%d/%s", port.ID, port.Protocol)
+			portID := fmt.Sprintf("port:%s:%s", address, portLabel)
+			graph.Nodes = append(graph.Nodes, GraphNode{ID: portID, Kind: GraphNodePort, Label: portLabel})
+			graph.Edges = append(graph.Edges, GraphEdge{From: hostID, To: portID})
+
+			if port.Service.Name == "" {
+				continue
+			}
+
+			serviceID := "service:" + port.Service.Name
+			if !services[serviceID] {
+				services[serviceID] = true
+				graph.Nodes = append(graph.Nodes, GraphNode{ID: serviceID, Kind: GraphNodeService, Label: port.Service.Name})
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: portID, To: serviceID})
+		}
+	}
+
+	return graph
+}
+
+// dotShapes are the shapes of the nodes of each kind in the DOT output.
+var dotShapes = map[string]string{
+	GraphNodeHost:    "box",
+	GraphNodePort:    "ellipse",
+	GraphNodeService: "diamond",
+}
+
+// ToDOT writes the graph in the DOT language of Graphviz, for instance to render it
+// with "dot -Tsvg".
+func (g Graph) ToDOT(w io.Writer) error {
+	nw := &normalWriter{w: w}
+
+	nw.printf("digraph scan {\n")
+	for _, node := range g.Nodes {
+		nw.printf("  %s [label=%s shape=%s];\n", dotQuote(node.ID), dotQuote(node.Label), dotShapes[node.Kind])
+	}
+	for _, edge := range g.Edges {
+		nw.printf("  %s -> %s;\n", dotQuote(edge.From), dotQuote(edge.To))
+	}
+	nw.printf("}\n")
+
+	return nw.err
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote quotes an identifier or a label of the DOT language.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,68 @@
+package RustScan
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestToGraph(t *testing.T) {
+	run, err := ParseFiles("testdata/merge1.xml", "testdata/merge2.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	graph := run.ToGraph()
+
+	// The host that is down is left out, and the https service is shared by two ports.
+	kinds := make(map[string]int)
+	for _, node := range graph.Nodes {
+		kinds[node.Kind]++
+	}
+	if kinds[GraphNodeHost] != 2 || kinds[GraphNodePort] != 4 || kinds[GraphNodeService] != 3 {
+		t.Errorf("expected 2 hosts, 4 ports and 3 services, got %v", kinds)
+	}
+	if len(graph.Edges) != 8 {
+		t.Errorf("expected 8 edges, got %d", len(graph.Edges))
+	}
+
+	nodes := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = true
+	}
+	for _, edge := range graph.Edges {
+		if !nodes[edge.From] || !nodes[edge.To] {
+			t.Errorf("edge %s -> %s links unknown nodes", edge.From, edge.To)
+		}
+	}
+}
+
+func TestGraphToDOT(t *testing.T) {
+	graph := Graph{
+		Nodes: []GraphNode{
+			{ID: "host:10.0.0.1", Kind: GraphNodeHost, Label: "10.0.0.1"},
+			{ID: "port:10.0.0.1:80/tcp", Kind: GraphNodePort, Label: "80/tcp"},
+			{ID: "service:http", Kind: GraphNodeService, Label: `say "hi"`},
+		},
+		Edges: []GraphEdge{
+			{From: "host:10.0.0.1", To: "port:10.0.0.1:80/tcp"},
+			{From: "port:10.0.0.1:80/tcp", To: "service:http"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := graph.ToDOT(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `digraph scan {
+  "host:10.0.0.1" [label="10.0.0.1" shape=box];
+  "port:10.0.0.1:80/tcp" [label="80/tcp" shape=ellipse];
+  "service:http" [label="say \"hi\"" shape=diamond];
+  "host:10.0.0.1" -> "port:10.0.0.1:80/tcp";
+  "port:10.0.0.1:80/tcp" -> "service:http";
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,60 @@
+package RustScan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseGreppableLine parses the lines RustScan prints in greppable mode (-g), which
+// list the open ports of a host such as "192.168.1.1 -> [22,80]". It returns false for
+// other lines, and an error for a port that is not valid.
+func parseGreppableLine(line string) ([]openPort, bool, error) {
+	line = strings.TrimSpace(stripANSI(line))
+
+	sep := strings.Index(line, " -> [")
+	if sep < 0 || !strings.HasSuffix(line, "]") {
+		return nil, false, nil
+	}
+
+	addr := strings.TrimSpace(line[:sep])
+	list := line[sep+len(" -> [") : len(line)-1]
+
+	var ports []openPort
+	for _, elem := range strings.Split(list, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(elem, 10, 16)
+		if err != nil || id == 0 {
+			return nil, true, fmt.Errorf("invalid port %q for %s", elem, addr)
+		}
+
+		ports = append(ports, openPort{addr: addr, port: uint16(id)})
+	}
+
+	return ports, true, nil
+}
+
+// ParseGreppable parses the output of RustScan in greppable mode (-g), one
+// "host -> [port1,port2]" line per host, into a Run. The hosts are up and their ports
+// are open TCP ports, like the ones of nmap's XML output, so that the result can be
+// used the same way; there is no service information since nmap does not run. Other
+// lines are ignored.
+func ParseGreppable(data []byte) (*Run, error) {
+	var found []openPort
+
+	for _, line := range strings.Split(string(data), "\n") {
+		ports, ok, err := parseGreppableLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, ports...)
+		}
+	}
+
+	return openPortsRun(found), nil
+}
@@ -0,0 +1,60 @@
+package RustScan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGreppable(t *testing.T) {
+	output := "[~] The config file is expected to be at \"/root/.rustscan.toml\"\n" +
+		"10.0.0.1 -> [22,80]\n" +
+		"\x1b[35m::1\x1b[0m -> [8080]\n" +
+		"10.0.0.2 -> []\n"
+
+	run, err := ParseGreppable([]byte(output))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A host without open ports is left out, like RustScan does not print it.
+	ports := make(map[string][]uint16)
+	for _, host := range run.Hosts {
+		if host.Status.State != "up" {
+			t.Errorf("expected %s to be up, got %q", host.Addresses[0].Addr, host.Status.State)
+		}
+		for _, port := range host.Ports {
+			if port.Status() != Open || port.Protocol != "tcp" {
+				t.Errorf("expected open TCP ports, got %s/%s", port.Status(), port.Protocol)
+			}
+			ports[host.Addresses[0].Addr] = append(ports[host.Addresses[0].Addr], port.ID)
+		}
+	}
+	if want := map[string][]uint16{"10.0.0.1": {22, 80}, "::1": {8080}}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("expected ports %v, got %v", want, ports)
+	}
+
+	if _, err := ParseGreppable([]byte("10.0.0.1 -> [22,http]\n")); err == nil {
+		t.Error("expected an error for an invalid port")
+	}
+}
+
+func TestWithGreppable(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80"), WithGreppable()).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Hosts) != 2 || countPorts(result) != 4 {
+		t.Fatalf("expected 2 hosts with 2 ports, got %+v", result.Hosts)
+	}
+	if service := result.Hosts[0].Ports[0].Service.Name; service != "" {
+		t.Errorf("expected no service information, got %q", service)
+	}
+
+	// The nmap stage does not run, so its options cannot be used.
+	if _, err := NewScanner(WithBinaryPath("rustscan"), WithGreppable(), WithServiceInfo()); err == nil {
+		t.Error("expected an error for an nmap option in greppable mode")
+	}
+}
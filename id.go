@@ -0,0 +1,22 @@
+package RustScan
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// newScanID returns a random version 4 UUID identifying a scan.
+func newScanID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// The system's random source is broken, which the clock can stand in for: the
+		// identifier only has to tell scans apart.
+		return fmt.Sprintf("scan-%d", time.Now().UnixNano())
+	}
+
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
@@ -0,0 +1,54 @@
+package RustScan
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewScanID(t *testing.T) {
+	first, second := newScanID(), newScanID()
+	if !uuidV4.MatchString(first) || !uuidV4.MatchString(second) {
+		t.Errorf("expected version 4 UUIDs, got %q and %q", first, second)
+	}
+	if first == second {
+		t.Errorf("expected different identifiers, got %q twice", first)
+	}
+}
+
+func TestWithScanID(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The identifier is set on the result and on every event of the scan.
+	var stream bytes.Buffer
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithScanID("scan-42"), WithEventStreamJSON(&stream)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.ScanID != "scan-42" {
+		t.Errorf("expected the scan ID on the result, got %q", result.ScanID)
+	}
+	for _, event := range decodeEvents(t, stream.Bytes()) {
+		if event.ScanID != "scan-42" {
+			t.Errorf("expected the scan ID on the %s event, got %q", event.Type, event.ScanID)
+		}
+	}
+
+	// Without it, every scan of a scanner gets an identifier of its own.
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"))
+	first, _, err := scanner.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _, err := scanner.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !uuidV4.MatchString(first.ScanID) || first.ScanID == second.ScanID {
+		t.Errorf("expected a random UUID for each scan, got %q and %q", first.ScanID, second.ScanID)
+	}
+}
@@ -0,0 +1,210 @@
+package RustScan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// MergeRuns combines several runs into a single one, for instance the results of a scan
+// that was distributed over several machines. Hosts are deduplicated by address: when
+// the same host appears in several runs, the ports missing from the first occurrence are
+// added to it, and a port found in several runs is kept from the one with the most
+// service detail. The scanner information of the first run is kept, the start time is the
+// earliest one and the finished statistics are the latest ones. The merged run has no
+// raw XML.
+func MergeRuns(runs ...*Run) *Run {
+	merged := &Run{}
+	hostIndex := make(map[string]int)
+	first := true
+
+	for _, run := range runs {
+		if run == nil {
+			continue
+		}
+
+		if first {
+			merged.XMLName = run.XMLName
+			merged.Name = run.Name
+			merged.ScanID = run.ScanID
+			merged.Args = run.Args
+			merged.ProfileName = run.ProfileName
+			merged.Scanner = run.Scanner
+			merged.StartStr = run.StartStr
+			merged.Version = run.Version
+			merged.XMLOutputVersion = run.XMLOutputVersion
+			merged.Debugging = run.Debugging
+			merged.ScanInfo = run.ScanInfo
+			merged.Start = run.Start
+			merged.Verbose = run.Verbose
+			merged.Stats.Finished = run.Stats.Finished
+			first = false
+		}
+
+		start := time.Time(run.Start)
+		if !start.IsZero() && (time.Time(merged.Start).IsZero() || start.Before(time.Time(merged.Start))) {
+			merged.Start = run.Start
+			merged.StartStr = run.StartStr
+		}
+
+		if time.Time(run.Stats.Finished.Time).After(time.Time(merged.Stats.Finished.Time)) {
+			merged.Stats.Finished = run.Stats.Finished
+		}
+
+		for _, host := range run.Hosts {
+			key := hostKey(host)
+			if idx, ok := hostIndex[key]; ok && key != "" {
+				merged.Hosts[idx] = mergeHosts(merged.Hosts[idx], host)
+				continue
+			}
+
+			hostIndex[key] = len(merged.Hosts)
+			merged.Hosts = append(merged.Hosts, host)
+		}
+
+		for key, value := range run.Metadata {
+			if merged.Metadata == nil {
+				merged.Metadata = make(map[string]string)
+			}
+			merged.Metadata[key] = value
+		}
+
+		merged.PostScripts = append(merged.PostScripts, run.PostScripts...)
+		merged.PreScripts = append(merged.PreScripts, run.PreScripts...)
+		merged.Targets = append(merged.Targets, run.Targets...)
+		merged.TaskBegin = append(merged.TaskBegin, run.TaskBegin...)
+		merged.TaskProgress = append(merged.TaskProgress, run.TaskProgress...)
+		merged.TaskEnd = append(merged.TaskEnd, run.TaskEnd...)
+		merged.NmapErrors = append(merged.NmapErrors, run.NmapErrors...)
+		merged.Phases = append(merged.Phases, run.Phases...)
+	}
+
+	merged.Stats.Hosts = HostStats{Total: len(merged.Hosts)}
+	for _, host := range merged.Hosts {
+		switch host.Status.State {
+		case "up":
+			merged.Stats.Hosts.Up++
+		case "down":
+			merged.Stats.Hosts.Down++
+		}
+	}
+
+	return merged
+}
+
+// ParseFiles parses the nmap XML files at the given paths and merges them into a single
+// run with MergeRuns. The returned error identifies the first file that could not be
+// read or parsed.
+func ParseFiles(paths ...string) (*Run, error) {
+	runs := make([]*Run, 0, len(paths))
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+
+		run, err := Parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	return MergeRuns(runs...), nil
+}
+
+// hostKey identifies a host by its first address, or its first hostname when it has
+// no address.
+func hostKey(host Host) string {
+	if len(host.Addresses) > 0 {
+		return host.Addresses[0].Addr
+	}
+
+	if len(host.Hostnames) > 0 {
+		return host.Hostnames[0].Name
+	}
+
+	return ""
+}
+
+// mergeHosts adds the ports and host scripts of other that dst does not have yet. A port
+// both hosts have is kept from the one that has the most detail about it.
+func mergeHosts(dst, other Host) Host {
+	ports := make([]Port, 0, len(dst.Ports)+len(other.Ports))
+	ports = append(ports, dst.Ports...)
+	dst.Ports = dedupePorts(append(ports, other.Ports...))
+
+	for _, script := range other.HostScripts {
+		if !hasScript(dst.HostScripts, script.ID) {
+			dst.HostScripts = append(dst.HostScripts, script)
+		}
+	}
+
+	if dst.Status.State != "up" && other.Status.State == "up" {
+		dst.Status = other.Status
+	}
+
+	return dst
+}
+
+// dedupePorts collapses the ports that share the same ID and protocol into the one with
+// the most detail, see portDetail, at the position of the first of them.
+func dedupePorts(ports []Port) []Port {
+	type portID struct {
+		id       uint16
+		protocol string
+	}
+
+	deduped := ports[:0:0]
+	index := make(map[portID]int, len(ports))
+
+	for _, port := range ports {
+		key := portID{id: port.ID, protocol: port.Protocol}
+		idx, ok := index[key]
+		if !ok {
+			index[key] = len(deduped)
+			deduped = append(deduped, port)
+			continue
+		}
+
+		if portDetail(port) > portDetail(deduped[idx]) {
+			deduped[idx] = port
+		}
+	}
+
+	return deduped
+}
+
+// portDetail scores how much a port entry tells about its service: one point for every
+// piece of service information and every script result.
+func portDetail(port Port) int {
+	detail := len(port.Scripts) + len(port.Service.CPEs)
+	for _, field := range []string{
+		port.Service.Name,
+		port.Service.Product,
+		port.Service.Version,
+		port.Service.ExtraInfo,
+		port.Service.OSType,
+		port.Service.DeviceType,
+		port.Service.Hostname,
+		port.Owner.Name,
+	} {
+		if field != "" {
+			detail++
+		}
+	}
+
+	return detail
+}
+
+func hasScript(scripts []Script, id string) bool {
+	for _, script := range scripts {
+		if script.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
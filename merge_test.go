@@ -0,0 +1,66 @@
+package RustScan
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFiles(t *testing.T) {
+	run, err := ParseFiles("testdata/merge1.xml", "testdata/merge2.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The hosts are deduplicated by address, in the order they were first found.
+	ports := make(map[string][]uint16)
+	var addrs []string
+	for _, host := range run.Hosts {
+		addr := host.Addresses[0].Addr
+		addrs = append(addrs, addr)
+		for _, port := range host.Ports {
+			ports[addr] = append(ports[addr], port.ID)
+		}
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("expected hosts %v, got %v", want, addrs)
+	}
+	if want := map[string][]uint16{"10.0.0.1": {22, 80, 443}, "10.0.0.3": {443}}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("expected ports %v, got %v", want, ports)
+	}
+
+	// The port found by both scans is kept from the one with the most service detail.
+	host, _ := run.HostByAddress("10.0.0.1")
+	if product := host.Ports[1].Service.Product; product != "nginx" {
+		t.Errorf("expected the detailed entry of port 80, got product %q", product)
+	}
+
+	if run.Version != "7.92" {
+		t.Errorf("expected the scanner information of the first file, got version %q", run.Version)
+	}
+	if start := time.Time(run.Start).Unix(); start != 1638862400 {
+		t.Errorf("expected the earliest start, got %d", start)
+	}
+	if finished := time.Time(run.Stats.Finished.Time).Unix(); finished != 1638862460 {
+		t.Errorf("expected the latest finish, got %d", finished)
+	}
+	if want := (HostStats{Up: 2, Down: 1, Total: 3}); run.Stats.Hosts != want {
+		t.Errorf("expected host stats %+v, got %+v", want, run.Stats.Hosts)
+	}
+}
+
+func TestParseFilesErrors(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	missing := filepath.Join(dir, "missing.xml")
+
+	for _, path := range []string{missing, "testdata/truncated.xml"} {
+		_, err := ParseFiles("testdata/merge1.xml", path)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: expected an error naming the file, got %v", path, err)
+		}
+	}
+}
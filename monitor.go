@@ -0,0 +1,48 @@
+package RustScan
+
+import "sync"
+
+// Monitor runs the same scan repeatedly and reports how port states changed from one
+// scan to the next. Each scan still starts its own RustScan process, see Scanner.
+type Monitor struct {
+	scanner ScanRunner
+
+	mutex    sync.Mutex
+	previous *Run
+}
+
+// NewMonitor creates a Monitor that runs scanner, usually a Scanner.
+func NewMonitor(scanner ScanRunner) *Monitor {
+	return &Monitor{
+		scanner: scanner,
+	}
+}
+
+// Scan runs the scan and returns the port transitions relative to the previous
+// successful scan, as computed by DiffRuns. The first scan only records a baseline and
+// returns no transitions. When the scan fails, the previous result is kept as the
+// reference for the next scan.
+func (m *Monitor) Scan() (transitions []PortTransition, warnings []string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result, warnings, err := m.scanner.Run()
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	if m.previous != nil {
+		transitions = DiffRuns(m.previous, result)
+	}
+	m.previous = result
+
+	return transitions, warnings, nil
+}
+
+// Previous returns the result of the last successful scan, or nil if there was none.
+func (m *Monitor) Previous() *Run {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.previous
+}
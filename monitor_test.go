@@ -0,0 +1,63 @@
+package RustScan
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubRunner returns its results one after the other, one for every scan.
+type stubRunner struct {
+	results []*Run
+	errs    []error
+	scans   int
+}
+
+func (r *stubRunner) Run() (*Run, []string, error) {
+	idx := r.scans
+	r.scans++
+
+	return r.results[idx], nil, r.errs[idx]
+}
+
+func TestMonitor(t *testing.T) {
+	errScan := errors.New("scan failed")
+
+	runner := &stubRunner{
+		results: []*Run{
+			newTestRun(testPort{"10.0.0.1", 22, Open}),
+			newTestRun(testPort{"10.0.0.1", 22, Open}, testPort{"10.0.0.1", 80, Open}),
+			nil,
+			newTestRun(testPort{"10.0.0.1", 80, Open}),
+		},
+		errs: []error{nil, nil, errScan, nil},
+	}
+
+	tests := []struct {
+		name string
+		want []PortTransition
+		err  error
+	}{
+		{"baseline", nil, nil},
+		{"port opened", []PortTransition{{Address: "10.0.0.1", Port: 80, Protocol: "tcp", From: Closed, To: Open}}, nil},
+		{"failed scan", nil, errScan},
+		// The failed scan is skipped, the reference is still the second scan.
+		{"port closed", []PortTransition{{Address: "10.0.0.1", Port: 22, Protocol: "tcp", From: Open, To: Closed}}, nil},
+	}
+
+	monitor := NewMonitor(runner)
+	for _, test := range tests {
+		transitions, _, err := monitor.Scan()
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		if !reflect.DeepEqual(transitions, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, transitions)
+		}
+	}
+
+	if monitor.Previous() != runner.results[3] {
+		t.Error("expected the last successful result as the previous one")
+	}
+}
@@ -0,0 +1,173 @@
+package RustScan
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ToNmapNormal writes the run in the human readable format of nmap's normal output (-oN),
+// with a report and a PORT/STATE/SERVICE table for each host that is up. It allows
+// regenerating the normal output of a scan from its XML without scanning again.
+func (r *Run) ToNmapNormal(w io.Writer) error {
+	nw := &normalWriter{w: w}
+
+	if r.StartStr != "" {
+		nw.printf("# Nmap %s scan initiated %s as: %s\n", r.Version, r.StartStr, r.Args)
+	}
+
+	for _, host := range r.Hosts {
+		if host.Status.State == "down" {
+			continue
+		}
+
+		nw.host(host)
+	}
+
+	hosts := r.Stats.Hosts
+	nw.printf("# Nmap done at %s -- %d IP %s (%d %s up) scanned in %.2f seconds\n",
+		r.Stats.Finished.TimeStr,
+		hosts.Total, plural(hosts.Total, "address", "addresses"),
+		hosts.Up, plural(hosts.Up, "host", "hosts"),
+		r.Stats.Finished.Elapsed,
+	)
+
+	return nw.err
+}
+
+// normalWriter writes nmap's normal output and keeps the first write error.
+type normalWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (nw *normalWriter) printf(format string, args ...interface{}) {
+	if nw.err != nil {
+		return
+	}
+
+	_, nw.err = fmt.Fprintf(nw.w, format, args...)
+}
+
+func (nw *normalWriter) host(host Host) {
+	name := hostKey(host)
+	if len(host.Addresses) > 0 && len(host.Hostnames) > 0 {
+		name = fmt.Sprintf("%s (%s)", host.Hostnames[0].Name, host.Addresses[0].Addr)
+	}
+	nw.printf("Nmap scan report for %s\n", name)
+
+	// The smoothed round trip time is reported in microseconds.
+	if srtt, err := strconv.Atoi(host.Times.SRTT); err == nil {
+		nw.printf("Host is up (%.3fs latency).\n", float64(srtt)/1e6)
+	} else {
+		nw.printf("Host is up.\n")
+	}
+
+	for _, extra := range host.ExtraPorts {
+		nw.printf("Not shown: %d %s ports\n", extra.Count, extra.State)
+	}
+
+	if len(host.Ports) > 0 {
+		nw.ports(host.Ports)
+	}
+
+	if len(host.HostScripts) > 0 {
+		nw.printf("\nHost script results:\n")
+		for _, script := range host.HostScripts {
+			nw.script(script)
+		}
+	}
+
+	nw.printf("\n")
+}
+
+// ports writes the port table of a host, with its columns aligned like nmap does.
+func (nw *normalWriter) ports(ports []Port) {
+	header := []string{"PORT", "STATE", "SERVICE"}
+
+	withVersion := false
+	for _, port := range ports {
+		if serviceVersion(port.Service) != "" {
+			withVersion = true
+			header = append(header, "VERSION")
+			break
+		}
+	}
+
+	rows := [][]string{header}
+	for _, port := range ports {
+		row := []string{fmt.Sprintf("%d/%s", port.ID, port.Protocol), port.State.State, port.Service.Name}
+		if withVersion {
+			row = append(row, serviceVersion(port.Service))
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for idx, cell := range row {
+			if len(cell) > widths[idx] {
+				widths[idx] = len(cell)
+			}
+		}
+	}
+
+	for idx, row := range rows {
+		cells := make([]string, len(row))
+		for col, cell := range row {
+			if col < len(row)-1 {
+				cell += strings.Repeat(" ", widths[col]-len(cell))
+			}
+			cells[col] = cell
+		}
+		nw.printf("%s\n", strings.TrimRight(strings.Join(cells, " "), " "))
+
+		// Script results are written under the port they belong to.
+		if idx > 0 {
+			for _, script := range ports[idx-1].Scripts {
+				nw.script(script)
+			}
+		}
+	}
+}
+
+// script writes the output of a script the way nmap does, with its last line prefixed
+// by "|_" and the other ones by "|". An output starting with a newline begins on the
+// line after the script ID.
+func (nw *normalWriter) script(script Script) {
+	lines := strings.Split(strings.TrimRight(script.Output, "\n"), "\n")
+	lines[0] = script.ID + ": " + lines[0]
+
+	for idx, line := range lines {
+		prefix := "| "
+		if idx == len(lines)-1 {
+			prefix = "|_"
+		}
+		nw.printf("%s%s\n", prefix, line)
+	}
+}
+
+// serviceVersion formats the version information of a service like nmap's VERSION column.
+func serviceVersion(service Service) string {
+	var parts []string
+	for _, part := range []string{service.Product, service.Version} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if service.ExtraInfo != "" {
+		parts = append(parts, "("+service.ExtraInfo+")")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+
+	return plural
+}
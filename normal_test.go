@@ -0,0 +1,24 @@
+package RustScan
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestToNmapNormal(t *testing.T) {
+	for _, name := range []string{"merge1", "scripts"} {
+		run, err := Parse(readFixture(t, name+".xml"))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		var normal bytes.Buffer
+		if err := run.ToNmapNormal(&normal); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		if want := string(readFixture(t, name+".nmap")); normal.String() != want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, want, normal.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package RustScan
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// hostWriter appends hosts to a file as lines of JSON while a scan runs, see
+// WithAppendOutputFile. Every line is written to the file as soon as the host is
+// decoded, so a crash only loses the hosts that were not decoded yet. It keeps the
+// first error, so that a failing write does not stop the scan, and is safe for
+// concurrent use.
+type hostWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	err     error
+	closed  bool
+}
+
+// openHostWriter opens the file at path for appending, creating it if needed.
+func openHostWriter(path string) (*hostWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hostWriter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// write appends hosts to the file. It does nothing on a nil writer.
+func (w *hostWriter) write(hosts ...Host) {
+	if w == nil {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed || w.err != nil {
+		return
+	}
+
+	for _, host := range hosts {
+		if err := w.encoder.Encode(host); err != nil {
+			w.err = err
+			return
+		}
+	}
+}
+
+// close flushes the hosts written to the file and closes it. It returns the first
+// error of a write, and can be called again once the file is closed.
+func (w *hostWriter) close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	if err := w.file.Sync(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return w.err
+}
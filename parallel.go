@@ -0,0 +1,93 @@
+package RustScan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// splitTargets splits the command line of a scan into one command line per target
+// host, each scanning a single host of the addresses (-a) with the same options.
+func splitTargets(args []string) [][]string {
+	hosts, rest, at := extractTargets(args)
+	if len(hosts) < 2 {
+		return [][]string{args}
+	}
+
+	split := make([][]string, 0, len(hosts))
+	for _, host := range hosts {
+		split = append(split, insertTargets(rest, at, []string{host}))
+	}
+
+	return split
+}
+
+// runParallel runs the RustScan processes of a scan concurrently, at most
+// s.perHostParallel at once. A process that fails does not stop the others: its error
+// is added to the warnings, and only returned when every process failed. The results
+// keep the order of the invocations.
+func (s *Scanner) runParallel(ctx context.Context, invocations [][]string, limit int, scan *scanState, progress func(float32)) ([]*Run, []string, error) {
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		results   = make([]*Run, len(invocations))
+		warnings  []string
+		errs      = make([]error, len(invocations))
+		completed int
+		semaphore = make(chan struct{}, s.perHostParallel)
+	)
+
+	for idx, args := range invocations {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(idx int, args []string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result, runWarnings, err := s.runAttempts(ctx, args, limit, scan, nil)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			warnings = append(warnings, runWarnings...)
+			if err != nil {
+				errs[idx] = err
+				warnings = append(warnings, fmt.Sprintf("scan of %s failed: %v", targetOf(args), err))
+			} else {
+				results[idx] = result
+				scan.checkpoint.complete(idx)
+			}
+
+			completed++
+			if progress != nil {
+				progress(float32(completed) / float32(len(invocations)))
+			}
+		}(idx, args)
+	}
+
+	wg.Wait()
+
+	var runs []*Run
+	for _, result := range results {
+		if result != nil {
+			runs = append(runs, result)
+		}
+	}
+
+	if len(runs) == 0 {
+		return nil, warnings, errs[0]
+	}
+
+	return runs, warnings, nil
+}
+
+// targetOf returns the addresses a command line scans, for error messages.
+func targetOf(args []string) string {
+	if values, _ := extractFlag(args, "-a"); len(values) > 0 {
+		return strings.Join(values, ",")
+	}
+
+	return "the targets"
+}
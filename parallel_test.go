@@ -0,0 +1,80 @@
+package RustScan
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want [][]string
+	}{
+		{"single host", []string{"-a", "10.0.0.1", "-p", "22"}, [][]string{{"-a", "10.0.0.1", "-p", "22"}}},
+		{
+			"several hosts",
+			[]string{"-a", "10.0.0.1,10.0.0.2", "-p", "22", "--", "-oX", "-"},
+			[][]string{{"-a", "10.0.0.1", "-p", "22", "--", "-oX", "-"}, {"-a", "10.0.0.2", "-p", "22", "--", "-oX", "-"}},
+		},
+	}
+
+	for _, test := range tests {
+		if got := splitTargets(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestWithPerHostParallel(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	argsFile := filepath.Join(dir, "args")
+	defer setFakeEnv(t, map[string]string{"FAKE_ARGS_FILE": argsFile, "FAKE_FAIL_HOST": "10.0.0.2"})()
+
+	result, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2", "10.0.0.3"), WithPorts("22,80"), WithPerHostParallel(2)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each host is scanned by a process of its own.
+	var targets []string
+	for _, args := range readArgsFile(t, argsFile) {
+		targets = append(targets, targetOf(args))
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 processes, got %v", targets)
+	}
+
+	// The failed host is reported, and the others merged in the order of the targets.
+	var addrs []string
+	for _, host := range result.Hosts {
+		addrs = append(addrs, host.Addresses[0].Addr)
+		if len(host.Ports) != 2 {
+			t.Errorf("expected 2 ports on %s, got %d", host.Addresses[0].Addr, len(host.Ports))
+		}
+	}
+	if want := []string{"10.0.0.1", "10.0.0.3"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("expected hosts %v, got %v", want, addrs)
+	}
+
+	found := false
+	for _, warning := range warnings {
+		found = found || strings.HasPrefix(warning, "scan of 10.0.0.2 failed")
+	}
+	if !found {
+		t.Errorf("expected the failure of 10.0.0.2 among the warnings, got %v", warnings)
+	}
+
+	// Run only fails when every host did.
+	if _, _, err := newFakeScanner(t, WithTargets("10.0.0.2"), WithPorts("22"), WithPerHostParallel(2)).Run(); err == nil {
+		t.Error("expected an error when every host failed")
+	}
+
+	if _, err := NewScanner(WithPerHostParallel(0)); err == nil {
+		t.Error("expected an error for a parallelism of 0")
+	}
+}
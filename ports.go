@@ -0,0 +1,398 @@
+package RustScan
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPortListLength is the length of the longest list of ports passed to RustScan, which
+// keeps its command line far from the limits of the operating systems: 128 KiB for an
+// argument on Linux, 32 KiB for the whole command line on Windows.
+const maxPortListLength = 16 << 10
+
+// splitRangePorts is the number of ports from which a range gets a RustScan process of
+// its own (-r) when the ports of a scan do not fit in a single list.
+const splitRangePorts = 256
+
+// portRange is an inclusive range of ports, a single port being a range of one.
+type portRange struct {
+	start, end int
+}
+
+func (r portRange) String() string {
+	if r.start == r.end {
+		return strconv.Itoa(r.start)
+	}
+
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// parsePortRanges parses a port specification such as "22,80,1000-1010" into the
+// ranges it contains, in the order they were given.
+func parsePortRanges(spec string) ([]portRange, error) {
+	var ranges []portRange
+
+	for _, elem := range strings.Split(spec, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(elem, "-", 2)
+
+		start, err := parsePort(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end := start
+		if len(bounds) == 2 {
+			end, err = parsePort(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if start > end {
+			return nil, fmt.Errorf("%w range %q", ErrInvalidPort, elem)
+		}
+
+		ranges = append(ranges, portRange{start: start, end: end})
+	}
+
+	return ranges, nil
+}
+
+// parsePorts expands a port specification such as "22,80,1000-1010" into the list of
+// ports it contains, in the order they were given.
+func parsePorts(spec string) ([]int, error) {
+	ranges, err := parsePortRanges(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandRanges(ranges), nil
+}
+
+// normalizePorts returns the union of the ports of several specifications as sorted
+// ranges, in which overlapping and adjacent ranges are coalesced so that "80-100" and
+// "90-110" become "80-110".
+func normalizePorts(specs ...string) ([]portRange, error) {
+	ranges, err := parsePortRanges(strings.Join(specs, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	var merged []portRange
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && r.start <= merged[last].end+1 {
+			if r.end > merged[last].end {
+				merged[last].end = r.end
+			}
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged, nil
+}
+
+// mergePortLists merges port specifications into a single sorted one without duplicates,
+// see normalizePorts. Ranges are kept as such rather than expanded. Specifications that
+// cannot be parsed are joined as they are, for Validate to report them.
+func mergePortLists(specs ...string) string {
+	ranges, err := normalizePorts(specs...)
+	if err != nil || len(ranges) == 0 {
+		return strings.Join(specs, ",")
+	}
+
+	return formatRanges(ranges)
+}
+
+// formatRanges joins ranges into a comma separated specification such as "22,80-90".
+func formatRanges(ranges []portRange) string {
+	elems := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		elems = append(elems, r.String())
+	}
+
+	return strings.Join(elems, ",")
+}
+
+// normalizePortArgs replaces every port list and port range of the arguments with a
+// single canonical one, see normalizePorts. RustScan only accepts ranges through -r,
+// so a single range is passed as such and anything else as a -p specification, which
+// splitPortArgs turns into lists of single ports.
+func normalizePortArgs(args []string) ([]string, error) {
+	own, nmap := splitNmapArgs(args)
+	specs, rest := extractFlag(own, "-p", "-r")
+	if len(specs) == 0 {
+		return args, nil
+	}
+
+	ranges, err := normalizePorts(specs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ports in port specification %q", strings.Join(specs, ","))
+	}
+
+	if len(ranges) == 1 && ranges[0].start != ranges[0].end {
+		return append(append(rest, "-r", ranges[0].String()), nmap...), nil
+	}
+
+	return append(append(rest, "-p", formatRanges(ranges)), nmap...), nil
+}
+
+// splitPortArgs expands the -p specification of the arguments into the list of single
+// ports RustScan expects. A list too long for a command line is split: each range of
+// at least splitRangePorts ports is scanned by a process of its own with -r, and the
+// other ports by processes scanning lists that fit, in the order they were given. It
+// returns the command lines of the processes.
+func splitPortArgs(args []string) ([][]string, error) {
+	own, nmap := splitNmapArgs(args)
+	specs, rest := extractFlag(own, "-p")
+	if len(specs) == 0 {
+		return [][]string{args}, nil
+	}
+
+	ranges, err := parsePortRanges(strings.Join(specs, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	withPorts := func(flag, value string) []string {
+		withPorts := make([]string, 0, len(rest)+2+len(nmap))
+		withPorts = append(append(withPorts, rest...), flag, value)
+		return append(withPorts, nmap...)
+	}
+
+	ports := expandRanges(ranges)
+	if portListLength(ports) <= maxPortListLength {
+		return [][]string{withPorts("-p", formatPorts(ports))}, nil
+	}
+
+	var (
+		split  [][]string
+		others []int
+	)
+	for _, r := range ranges {
+		if r.end-r.start+1 >= splitRangePorts {
+			split = append(split, withPorts("-r", r.String()))
+			continue
+		}
+
+		others = append(others, expandRanges([]portRange{r})...)
+	}
+
+	for len(others) > 0 {
+		n, length := 0, 0
+		for n < len(others) && length+len(strconv.Itoa(others[n]))+1 <= maxPortListLength {
+			length += len(strconv.Itoa(others[n])) + 1
+			n++
+		}
+
+		split = append(split, withPorts("-p", formatPorts(others[:n])))
+		others = others[n:]
+	}
+
+	return split, nil
+}
+
+// portListLength returns the length of the list formatPorts makes of ports.
+func portListLength(ports []int) int {
+	length := 0
+	for _, port := range ports {
+		length += len(strconv.Itoa(port)) + 1
+	}
+
+	return length - 1
+}
+
+// normalizeExcludeArgs merges the excluded ports (-e) of the arguments into a single
+// sorted list with ranges expanded, since RustScan only accepts single ports there.
+// When a list or range of ports is set, only the excluded ports within it are kept.
+func normalizeExcludeArgs(args []string) ([]string, error) {
+	own, nmap := splitNmapArgs(args)
+	specs, rest := extractFlag(own, "-e")
+	if len(specs) == 0 {
+		return args, nil
+	}
+
+	ranges, err := normalizePorts(specs...)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := expandRanges(ranges)
+
+	if scanned, _ := extractFlag(rest, "-p", "-r"); len(scanned) > 0 {
+		scannedRanges, err := parsePortRanges(strings.Join(scanned, ","))
+		if err != nil {
+			return nil, err
+		}
+
+		kept := excluded[:0]
+		for _, port := range excluded {
+			for _, r := range scannedRanges {
+				if port >= r.start && port <= r.end {
+					kept = append(kept, port)
+					break
+				}
+			}
+		}
+		excluded = kept
+	}
+
+	if len(excluded) == 0 {
+		return append(rest, nmap...), nil
+	}
+
+	// Unlike the ports to scan, excluded ports cannot be split across processes.
+	if portListLength(excluded) > maxPortListLength {
+		return nil, fmt.Errorf("%d excluded ports are too many for RustScan's command line, which only accepts single excluded ports", len(excluded))
+	}
+
+	return append(append(rest, "-e", formatPorts(excluded)), nmap...), nil
+}
+
+// excludesAllPorts reports whether the excluded ports (-e) of the arguments cover all
+// the ports they scan, every port when no list or range is set. Only the arguments of
+// RustScan are considered.
+func excludesAllPorts(args []string) bool {
+	args = rustScanArgs(args)
+	excluded, _ := extractFlag(args, "-e")
+	if len(excluded) == 0 || containsString(args, "--top") {
+		return false
+	}
+
+	specs, _ := extractFlag(args, "-p", "-r")
+	if len(specs) == 0 {
+		specs = []string{"1-65535"}
+	}
+
+	ports, err := parsePorts(strings.Join(specs, ","))
+	if err != nil {
+		return false
+	}
+
+	skipped, err := parsePorts(strings.Join(excluded, ","))
+	if err != nil {
+		return false
+	}
+
+	skip := make(map[int]bool, len(skipped))
+	for _, port := range skipped {
+		skip[port] = true
+	}
+
+	for _, port := range ports {
+		if !skip[port] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func expandRanges(ranges []portRange) []int {
+	var ports []int
+	for _, r := range ranges {
+		for port := r.start; port <= r.end; port++ {
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidPort, s)
+	}
+
+	return port, nil
+}
+
+// formatPorts joins ports into a comma separated list.
+func formatPorts(ports []int) string {
+	elems := make([]string, 0, len(ports))
+	for _, port := range ports {
+		elems = append(elems, strconv.Itoa(port))
+	}
+
+	return strings.Join(elems, ",")
+}
+
+// splitNmapArgs splits the arguments into the ones of RustScan itself, before "--", and
+// the ones of its nmap stage, from "--" on. The flags of one are not those of the other:
+// -p or -e after "--" are nmap's ports and network interface.
+func splitNmapArgs(args []string) (own, nmap []string) {
+	own = rustScanArgs(args)
+	return own, args[len(own):]
+}
+
+// extractFlag removes every occurrence of the given flags and their value from args.
+// It returns the removed values and the remaining arguments.
+func extractFlag(args []string, flags ...string) (values, rest []string) {
+	for i := 0; i < len(args); i++ {
+		if !containsString(flags, args[i]) || i+1 == len(args) {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		values = append(values, args[i+1])
+		i++
+	}
+
+	return values, rest
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orderPorts replaces the port arguments with an explicit -p list in the order given
+// by the custom port order function.
+func orderPorts(args []string, order func([]int) []int) ([]string, error) {
+	own, nmap := splitNmapArgs(args)
+	specs, rest := extractFlag(own, "-p", "-r")
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("a custom port order requires ports to be set with WithPorts")
+	}
+
+	ports, err := parsePorts(strings.Join(specs, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := order(ports)
+	if len(ordered) == 0 {
+		return nil, fmt.Errorf("custom port order returned no ports")
+	}
+
+	for _, port := range ordered {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("custom port order returned invalid port %d", port)
+		}
+	}
+
+	return append(append(rest, "-p", formatPorts(ordered)), nmap...), nil
+}
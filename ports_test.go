@@ -0,0 +1,347 @@
+package RustScan
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		want  string
+	}{
+		{"overlapping", []string{"80-100", "90-110"}, "80-110"},
+		{"contained", []string{"1-1000", "22,80"}, "1-1000"},
+		{"adjacent", []string{"80-100", "101-110"}, "80-110"},
+		{"adjacent single ports", []string{"22,23,24"}, "22-24"},
+		{"disjoint", []string{"443", "22", "8000-8080"}, "22,443,8000-8080"},
+		{"duplicates", []string{"80,443", "443,8080"}, "80,443,8080"},
+		{"spaces and empty elements", []string{" 22 ,,80 "}, "22,80"},
+	}
+
+	for _, test := range tests {
+		ranges, err := normalizePorts(test.specs...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if got := formatRanges(ranges); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestNormalizePortsInvalid(t *testing.T) {
+	for _, spec := range []string{"http", "0", "70000", "100-90", "1-", "-5"} {
+		if _, err := normalizePorts(spec); !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("%q: expected ErrInvalidPort, got %v", spec, err)
+		}
+	}
+}
+
+func TestNormalizePortArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-a", "10.0.0.1", "-p", "80-100", "-p", "90-110"}, []string{"-a", "10.0.0.1", "-r", "80-110"}},
+		{[]string{"-p", "22", "-r", "1-21"}, []string{"-r", "1-22"}},
+		{[]string{"-p", "443,22"}, []string{"-p", "22,443"}},
+		{[]string{"-p", "1-1000", "-p", "2000-65535"}, []string{"-p", "1-1000,2000-65535"}},
+		{[]string{"-a", "10.0.0.1"}, []string{"-a", "10.0.0.1"}},
+		{[]string{"-p", "443,22", "--", "-sV"}, []string{"-p", "22,443", "--", "-sV"}},
+		{[]string{"-r", "1-100", "--", "-p", "443"}, []string{"-r", "1-100", "--", "-p", "443"}},
+		{[]string{"-a", "10.0.0.1", "--", "-p", "443"}, []string{"-a", "10.0.0.1", "--", "-p", "443"}},
+	}
+
+	for _, test := range tests {
+		got, err := normalizePortArgs(test.args)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.args, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %v, got %v", test.args, test.want, got)
+		}
+	}
+}
+
+func TestSplitPortArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want [][]string
+	}{
+		{"short list", "22,80-82", [][]string{{"-a", "h", "-p", "22,80,81,82", "--", "-p", "443"}}},
+		{"large ranges", "1-1000,2000-65535", [][]string{{"-a", "h", "-r", "1-1000", "--", "-p", "443"}, {"-a", "h", "-r", "2000-65535", "--", "-p", "443"}}},
+	}
+
+	for _, test := range tests {
+		got, err := splitPortArgs([]string{"-a", "h", "-p", test.spec, "--", "-p", "443"})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestSplitPortArgsManyPorts(t *testing.T) {
+	// Every other port up to 20000, along with a large range: no list of them fits on a
+	// command line, but together the processes scan each of them exactly once.
+	var elems []string
+	for port := 1; port <= 20000; port += 2 {
+		elems = append(elems, formatPorts([]int{port}))
+	}
+	elems = append(elems, "30000-40000")
+	spec := strings.Join(elems, ",")
+
+	split, err := splitPortArgs([]string{"-a", "h", "-p", spec})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(split) < 2 {
+		t.Fatalf("expected the ports to be split, got %d command lines", len(split))
+	}
+
+	seen := make(map[int]int)
+	for _, args := range split {
+		if args[0] != "-a" || args[1] != "h" || len(args) != 4 {
+			t.Fatalf("unexpected command line %.80v", args)
+		}
+		if len(args[3]) > maxPortListLength {
+			t.Errorf("port argument of %d bytes is longer than %d", len(args[3]), maxPortListLength)
+		}
+
+		ports, err := parsePorts(args[3])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if args[2] == "-r" && len(ports) < splitRangePorts {
+			t.Errorf("range %s of fewer than %d ports has a process of its own", args[3], splitRangePorts)
+		}
+		for _, port := range ports {
+			seen[port]++
+		}
+	}
+
+	want, _ := parsePorts(spec)
+	if len(seen) != len(want) {
+		t.Errorf("expected %d ports, got %d", len(want), len(seen))
+	}
+	for _, port := range want {
+		if seen[port] != 1 {
+			t.Errorf("port %d is scanned %d times", port, seen[port])
+		}
+	}
+}
+
+func TestNormalizeExcludeArgs(t *testing.T) {
+	got, err := normalizeExcludeArgs([]string{"-p", "20,21,22,80", "-e", "22,21", "-e", "443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"-p", "20,21,22,80", "-e", "21,22"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// nmap's -e is its network interface, which is left where it is.
+	got, err = normalizeExcludeArgs([]string{"-p", "22,80", "-e", "80", "--", "-e", "eth0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"-p", "22,80", "-e", "80", "--", "-e", "eth0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := normalizeExcludeArgs([]string{"-r", "1-65535", "-e", "1-30000"}); err == nil {
+		t.Error("expected an error for excluded ports too many for a command line")
+	}
+}
+
+func TestScannerInvocationsSplitPorts(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithPorts("1-1000", "2000-65535"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := [][]string{
+		{"-a", "10.0.0.1", "-r", "1-1000", "--", "-oX", "-"},
+		{"-a", "10.0.0.1", "-r", "2000-65535", "--", "-oX", "-"},
+	}
+	if !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+}
+
+func TestWithCustomPortOrder(t *testing.T) {
+	reverse := func(ports []int) []int {
+		ordered := make([]int, 0, len(ports))
+		for idx := len(ports) - 1; idx >= 0; idx-- {
+			ordered = append(ordered, ports[idx])
+		}
+		return ordered
+	}
+
+	tests := []struct {
+		name  string
+		order func([]int) []int
+		want  [][]string
+		err   bool
+	}{
+		// nmap's ports after "--" are not reordered along with RustScan's.
+		{"reversed", reverse, [][]string{{"-a", "10.0.0.1", "-p", "443,81,80,22", "--", "-p", "8080", "-oX", "-"}}, false},
+		{"no ports", func([]int) []int { return nil }, nil, true},
+		{"invalid port", func([]int) []int { return []int{70000} }, nil, true},
+	}
+
+	for _, test := range tests {
+		scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithPorts("22,80-81,443"), WithCustomPortOrder(test.order), WithCustomArguments("--", "-p", "8080"))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		invocations, err := scanner.invocations()
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(invocations, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, invocations)
+		}
+	}
+}
+
+func TestExcludesAllPorts(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-p", "22,80", "-e", "22,80"}, true},
+		{[]string{"-r", "20-22", "-e", "20,21,22"}, true},
+		{[]string{"-p", "22,80", "-e", "22"}, false},
+		{[]string{"-p", "22,80"}, false},
+		{[]string{"-e", "1-1000"}, false},
+		{[]string{"--top", "-e", "22"}, false},
+		{[]string{"-p", "22", "--", "-e", "eth0"}, false},
+		{[]string{"-p", "22", "-e", "22", "--", "-p", "80"}, true},
+	}
+
+	for _, test := range tests {
+		if got := excludesAllPorts(test.args); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.args, test.want, got)
+		}
+	}
+}
+
+func TestWithExcludePortsEverything(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	_, warnings, _ := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80"), WithExcludePorts("22", "80")).Run()
+
+	found := false
+	for _, warning := range warnings {
+		found = found || strings.Contains(warning, "every port to scan is excluded")
+	}
+	if !found {
+		t.Errorf("expected a warning that nothing is scanned, got %v", warnings)
+	}
+
+	if _, err := NewScanner(WithExcludePorts("http")); !errors.Is(err, ErrInvalidPort) {
+		t.Errorf("expected ErrInvalidPort, got %v", err)
+	}
+}
+
+func TestWithRangeInvalid(t *testing.T) {
+	tests := []struct {
+		start, end int
+	}{
+		{0, 1000},
+		{1, 65536},
+		{1000, 999},
+		{-5, -1},
+	}
+
+	for _, test := range tests {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithRange(test.start, test.end)); !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("%d-%d: expected ErrInvalidPort, got %v", test.start, test.end, err)
+		}
+	}
+}
+
+func TestMergePortLists(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  string
+	}{
+		{[]string{"80,443", "443,8080"}, "80,443,8080"},
+		{[]string{"443", "22"}, "22,443"},
+		{[]string{"80-100", "90-110"}, "80-110"},
+		{[]string{"22", "http"}, "22,http"},
+	}
+
+	for _, test := range tests {
+		if got := mergePortLists(test.specs...); got != test.want {
+			t.Errorf("%v: expected %q, got %q", test.specs, test.want, got)
+		}
+	}
+}
+
+func TestWithPortsMerged(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithPorts("80,443"), WithPorts("443,8080"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The ports are merged as the options are applied, each of them once.
+	if want := []string{"-a", "10.0.0.1", "-p", "80,443,8080"}; !reflect.DeepEqual(scanner.Args(), want) {
+		t.Errorf("expected %v, got %v", want, scanner.Args())
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := [][]string{{"-a", "10.0.0.1", "-p", "80,443,8080", "--", "-oX", "-"}}; !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+}
+
+func TestWithPortsInvalid(t *testing.T) {
+	for _, spec := range []string{"http", "0", "65536", "100-90", "1-2-3"} {
+		_, err := NewScanner(WithBinaryPath("rustscan"), WithPorts(spec))
+		if !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("%q: expected ErrInvalidPort, got %v", spec, err)
+		}
+	}
+
+	// An invalid call leaves the ports of the others untouched.
+	scanner := &Scanner{}
+	WithPorts("22")(scanner)
+	WithPorts("http")(scanner)
+	if want := []string{"-p", "22"}; !reflect.DeepEqual(scanner.args, want) || len(scanner.errs) != 1 {
+		t.Errorf("expected %v and an error, got %v and %v", want, scanner.args, scanner.errs)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package RustScan
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// niceCommand returns the command unchanged since process niceness is not supported
+// on this platform.
+func niceCommand(niceness int, name string, args []string) (string, []string, error) {
+	return name, args, fmt.Errorf("process niceness is not supported on %s", runtime.GOOS)
+}
+
+// isPrivileged reports true since privileges cannot be checked on this platform, and
+// nmap tells by itself when it lacks them.
+func isPrivileged() bool {
+	return true
+}
+
+// terminate returns an error since processes cannot be asked to exit on this platform,
+// they are killed instead.
+func terminate(process *os.Process) error {
+	return fmt.Errorf("terminating a process is not supported on %s", runtime.GOOS)
+}
+
+// setProcessGroup does nothing since process groups are not supported on this platform.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills a process. The processes it spawned are not killed on this
+// platform.
+func killProcess(process *os.Process) error {
+	return process.Kill()
+}
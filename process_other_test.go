@@ -0,0 +1,27 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package RustScan
+
+import (
+	"strings"
+	"testing"
+)
+
+// processPriority returns 0 since process niceness is not supported on this platform.
+func processPriority() int {
+	return 0
+}
+
+func TestWithNiceness(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	_, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithNiceness(5)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not supported") {
+		t.Errorf("expected a warning that niceness is not supported, got %v", warnings)
+	}
+}
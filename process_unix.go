@@ -0,0 +1,51 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package RustScan
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// niceCommand wraps a command with nice(1), so that RustScan and the nmap process it
+// spawns all run with the given niceness.
+func niceCommand(niceness int, name string, args []string) (string, []string, error) {
+	nice, err := exec.LookPath("nice")
+	if err != nil {
+		return name, args, err
+	}
+
+	return nice, append([]string{"-n", strconv.Itoa(niceness), name}, args...), nil
+}
+
+// isPrivileged reports whether the process runs as root, which nmap needs to send raw packets.
+func isPrivileged() bool {
+	return os.Geteuid() == 0
+}
+
+// setProcessGroup makes a command start in a process group of its own, so that the
+// nmap processes RustScan spawns can be stopped along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate asks a process and the processes of its group to exit.
+func terminate(process *os.Process) error {
+	if err := syscall.Kill(-process.Pid, syscall.SIGTERM); err == nil {
+		return nil
+	}
+
+	return process.Signal(syscall.SIGTERM)
+}
+
+// killProcess kills a process and the processes of its group.
+func killProcess(process *os.Process) error {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+
+	return process.Kill()
+}
@@ -0,0 +1,194 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package RustScan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processPriority returns the scheduling priority of the process as getpriority(2)
+// reports it, which is 20 minus the niceness on Linux and the niceness elsewhere.
+func processPriority() int {
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return -1
+	}
+
+	return priority
+}
+
+func TestWithNiceness(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_PRINT_PRIORITY": "1"})()
+
+	_, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithNiceness(5)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// nice(1) adds to the niceness of the test, which getpriority(2) reports either
+	// as is or subtracted from 20 depending on the platform.
+	priority, found := 0, false
+	for _, warning := range warnings {
+		if _, err := fmt.Sscanf(warning, "priority %d", &priority); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("expected the priority of the scan among the warnings, got %v", warnings)
+	}
+
+	if own := processPriority(); priority-own != 5 && own-priority != 5 {
+		t.Errorf("expected the priority to change by 5 from %d, got %d", own, priority)
+	}
+}
+
+func TestWithStrictPrivilegeCheck(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	tests := []struct {
+		name       string
+		options    []Option
+		privileged bool
+	}{
+		{"connect scan", []Option{WithNmapArguments("-sT")}, false},
+		{"SYN scan", []Option{WithNmapArguments("-sS")}, true},
+		{"OS detection", []Option{WithOSDetection()}, true},
+		{"custom arguments", []Option{WithCustomArguments("--", "-sU")}, true},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithTargets("10.0.0.1"), WithPorts("22"), WithStrictPrivilegeCheck()}, test.options...)
+		_, _, err := newFakeScanner(t, options...).Run()
+
+		// Only the scan types that need raw packets fail, unless the test runs as root.
+		if test.privileged && os.Geteuid() != 0 {
+			if !errors.Is(err, ErrPrivilegesRequired) {
+				t.Errorf("%s: expected ErrPrivilegesRequired, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+
+	// Without the option, the scan is left to fail in nmap.
+	if _, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithNmapArguments("-sS")).Run(); errors.Is(err, ErrPrivilegesRequired) {
+		t.Errorf("unexpected ErrPrivilegesRequired without the strict check")
+	}
+}
+
+func TestWithKillGracePeriod(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		grace  time.Duration
+		ignore bool
+		result bool
+	}{
+		// The process is killed right away, and nothing is returned.
+		{"no grace period", 0, false, false},
+		// The process exits on SIGTERM, long before the grace period ends.
+		{"clean exit", time.Minute, false, true},
+		// The process ignores SIGTERM, and is killed once the grace period ends.
+		{"killed after grace period", 300 * time.Millisecond, true, true},
+	}
+
+	for idx, test := range tests {
+		env := map[string]string{"FAKE_WAIT_FILE": filepath.Join(dir, "never")}
+		termFile := filepath.Join(dir, fmt.Sprintf("term%d", idx))
+		if test.ignore {
+			env["FAKE_TERM_FILE"] = termFile
+		}
+		restore := setFakeEnv(t, env)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		start := time.Now()
+		result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80"), WithContext(ctx), WithKillGracePeriod(test.grace)).Run()
+		elapsed := time.Since(start)
+		cancel()
+		restore()
+
+		if !errors.Is(err, ErrScanTimeout) {
+			t.Errorf("%s: expected ErrScanTimeout, got %v", test.name, err)
+		}
+		if !test.ignore && test.grace > 0 && elapsed >= test.grace {
+			t.Errorf("%s: expected the process to exit on SIGTERM, took %v", test.name, elapsed)
+		}
+
+		if test.ignore {
+			if _, err := os.Stat(termFile); err != nil {
+				t.Errorf("%s: expected the process to get SIGTERM: %v", test.name, err)
+			}
+			if elapsed < 200*time.Millisecond+test.grace {
+				t.Errorf("%s: expected the process to be killed after the grace period, took %v", test.name, elapsed)
+			}
+		}
+
+		// The partial result holds the open ports RustScan reported.
+		if !test.result {
+			if result != nil {
+				t.Errorf("%s: unexpected result %+v", test.name, result)
+			}
+			continue
+		}
+		if result == nil || len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 2 {
+			t.Errorf("%s: expected the 2 open ports of the host, got %+v", test.name, result)
+		}
+	}
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	pidFile := filepath.Join(dir, "pid")
+	defer setFakeEnv(t, map[string]string{"FAKE_CHILD_PID_FILE": pidFile, "FAKE_WAIT_FILE": filepath.Join(dir, "never")})()
+
+	// The child keeps the stdout of RustScan open, so the scan only ends quickly when
+	// the child is killed along with RustScan.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithContext(ctx)).Run(); !errors.Is(err, ErrScanTimeout) {
+		t.Errorf("expected ErrScanTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the scan to stop when cancelled, took %v", elapsed)
+	}
+
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(string(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The child is gone, or left as a zombie for its new parent to reap.
+	if stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil && !strings.Contains(string(stat), ") Z ") {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("expected the child process %d to be killed, got %s", pid, stat)
+	}
+}
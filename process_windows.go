@@ -0,0 +1,46 @@
+//go:build windows
+// +build windows
+
+package RustScan
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// niceCommand returns the command unchanged since process niceness is not supported
+// on Windows.
+func niceCommand(niceness int, name string, args []string) (string, []string, error) {
+	return name, args, fmt.Errorf("process niceness is not supported on windows")
+}
+
+// isPrivileged reports true since privileges are not checked on Windows, and nmap tells
+// by itself when it lacks them.
+func isPrivileged() bool {
+	return true
+}
+
+// setProcessGroup makes a command start in a process group of its own, so that the
+// nmap processes RustScan spawns can be stopped along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminate returns an error since processes cannot be asked to exit on Windows, they
+// are killed instead.
+func terminate(process *os.Process) error {
+	return fmt.Errorf("terminating a process is not supported on windows")
+}
+
+// killProcess kills a process and the processes it spawned with taskkill, or only the
+// process when taskkill fails.
+func killProcess(process *os.Process) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid)).Run(); err == nil {
+		return nil
+	}
+
+	return process.Kill()
+}
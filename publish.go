@@ -0,0 +1,67 @@
+package RustScan
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Publisher publishes messages to a message broker, such as a Kafka or NATS producer.
+// Any broker can be used with WithPublisher, which keeps this package free of a
+// dependency on a specific client.
+type Publisher interface {
+	Publish(topic string, msg []byte) error
+}
+
+// Enumerates the topics of the messages published by WithPublisher.
+const (
+	// TopicHost receives a HostMessage for every host of the result.
+	TopicHost = "rustscan.host"
+	// TopicPort receives a PortMessage for every port of the result.
+	TopicPort = "rustscan.port"
+)
+
+// HostMessage is the JSON message published on TopicHost.
+type HostMessage struct {
+	// ScanID identifies the scan, see WithScanID.
+	ScanID string `json:"scan_id"`
+	// Name is the label set with WithScanName.
+	Name string `json:"name,omitempty"`
+	Host Host   `json:"host"`
+}
+
+// PortMessage is the JSON message published on TopicPort.
+type PortMessage struct {
+	// ScanID identifies the scan, see WithScanID.
+	ScanID string `json:"scan_id"`
+	// Name is the label set with WithScanName.
+	Name string `json:"name,omitempty"`
+	// Address is the address of the host of the port.
+	Address string `json:"address"`
+	Port    Port   `json:"port"`
+}
+
+// publish publishes the hosts and ports of a result, and returns a warning for every
+// message that could not be published.
+func publish(result *Run, publisher Publisher, scanID, name string) []string {
+	var warnings []string
+
+	send := func(topic string, msg interface{}) {
+		data, err := json.Marshal(msg)
+		if err == nil {
+			err = publisher.Publish(topic, data)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to publish to %s: %v", topic, err))
+		}
+	}
+
+	for _, host := range result.Hosts {
+		send(TopicHost, HostMessage{ScanID: scanID, Name: name, Host: host})
+
+		for _, port := range host.Ports {
+			send(TopicPort, PortMessage{ScanID: scanID, Name: name, Address: hostKey(host), Port: port})
+		}
+	}
+
+	return warnings
+}
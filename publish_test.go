@@ -0,0 +1,65 @@
+package RustScan
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordingPublisher records the messages it is given, and fails for the ports of
+// failPort.
+type recordingPublisher struct {
+	topics   []string
+	messages [][]byte
+	failPort uint16
+}
+
+func (p *recordingPublisher) Publish(topic string, msg []byte) error {
+	if topic == TopicPort && p.failPort != 0 {
+		var port PortMessage
+		if err := json.Unmarshal(msg, &port); err == nil && port.Port.ID == p.failPort {
+			return errors.New("broker unavailable")
+		}
+	}
+
+	p.topics = append(p.topics, topic)
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func TestWithPublisher(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	publisher := &recordingPublisher{failPort: 80}
+	_, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80"), WithScanID("scan-1"), WithPublisher(publisher)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every host is followed by its ports, except the ones the broker refused.
+	want := []string{TopicHost, TopicPort, TopicHost, TopicPort}
+	if strings.Join(publisher.topics, " ") != strings.Join(want, " ") {
+		t.Errorf("expected topics %v, got %v", want, publisher.topics)
+	}
+
+	var host HostMessage
+	if err := json.Unmarshal(publisher.messages[0], &host); err != nil || host.ScanID != "scan-1" || host.Host.Addresses[0].Addr != "10.0.0.1" {
+		t.Errorf("unexpected host message %s: %v", publisher.messages[0], err)
+	}
+
+	var port PortMessage
+	if err := json.Unmarshal(publisher.messages[1], &port); err != nil || port.ScanID != "scan-1" || port.Address != "10.0.0.1" || port.Port.ID != 22 {
+		t.Errorf("unexpected port message %s: %v", publisher.messages[1], err)
+	}
+
+	failures := 0
+	for _, warning := range warnings {
+		if strings.Contains(warning, "unable to publish to "+TopicPort) {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("expected a warning for each of the 2 refused messages, got %v", warnings)
+	}
+}
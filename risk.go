@@ -0,0 +1,102 @@
+package RustScan
+
+import "sort"
+
+// Enumerates the risk tiers of ports, from the most to the least sensitive.
+const (
+	// RiskTierAdmin covers remote administration and file sharing services.
+	RiskTierAdmin = "admin"
+	// RiskTierDB covers databases, caches and search engines.
+	RiskTierDB = "db"
+	// RiskTierWeb covers web servers and web applications.
+	RiskTierWeb = "web"
+	// RiskTierOther covers every other port.
+	RiskTierOther = "other"
+)
+
+// riskTierRanks orders the risk tiers, the ones scanned first having the lowest rank.
+var riskTierRanks = map[string]int{
+	RiskTierAdmin: 0,
+	RiskTierDB:    1,
+	RiskTierWeb:   2,
+	RiskTierOther: 3,
+}
+
+// riskTiers is the built-in mapping of well-known ports to their risk tier.
+var riskTiers = map[int]string{
+	// Remote administration and file sharing.
+	21:    RiskTierAdmin,
+	22:    RiskTierAdmin,
+	23:    RiskTierAdmin,
+	135:   RiskTierAdmin,
+	139:   RiskTierAdmin,
+	161:   RiskTierAdmin,
+	445:   RiskTierAdmin,
+	2049:  RiskTierAdmin,
+	2222:  RiskTierAdmin,
+	2375:  RiskTierAdmin,
+	2376:  RiskTierAdmin,
+	3389:  RiskTierAdmin,
+	5900:  RiskTierAdmin,
+	5985:  RiskTierAdmin,
+	5986:  RiskTierAdmin,
+	6443:  RiskTierAdmin,
+	10250: RiskTierAdmin,
+
+	// Databases, caches and search engines.
+	1433:  RiskTierDB,
+	1521:  RiskTierDB,
+	3306:  RiskTierDB,
+	5432:  RiskTierDB,
+	5984:  RiskTierDB,
+	6379:  RiskTierDB,
+	9042:  RiskTierDB,
+	9200:  RiskTierDB,
+	9300:  RiskTierDB,
+	11211: RiskTierDB,
+	27017: RiskTierDB,
+
+	// Web servers and web applications.
+	80:   RiskTierWeb,
+	443:  RiskTierWeb,
+	3000: RiskTierWeb,
+	5000: RiskTierWeb,
+	8000: RiskTierWeb,
+	8008: RiskTierWeb,
+	8080: RiskTierWeb,
+	8081: RiskTierWeb,
+	8443: RiskTierWeb,
+	8888: RiskTierWeb,
+	9443: RiskTierWeb,
+}
+
+// PortRiskTier returns the risk tier of a port according to the built-in mapping used
+// by WithRiskTierOrder: RiskTierAdmin, RiskTierDB, RiskTierWeb or RiskTierOther.
+func PortRiskTier(port int) string {
+	if tier, ok := riskTiers[port]; ok {
+		return tier
+	}
+
+	return RiskTierOther
+}
+
+// orderByRiskTier orders ports from the most to the least sensitive risk tier, keeping
+// their order within a tier.
+func orderByRiskTier(ports []int) []int {
+	ordered := append([]int(nil), ports...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return riskTierRanks[PortRiskTier(ordered[i])] < riskTierRanks[PortRiskTier(ordered[j])]
+	})
+
+	return ordered
+}
+
+// tagRiskTiers sets the risk tier of every port of the result.
+func tagRiskTiers(result *Run) {
+	for hostIdx := range result.Hosts {
+		ports := result.Hosts[hostIdx].Ports
+		for portIdx := range ports {
+			ports[portIdx].RiskTier = PortRiskTier(int(ports[portIdx].ID))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package RustScan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPortRiskTier(t *testing.T) {
+	tests := []struct {
+		port int
+		want string
+	}{
+		{22, RiskTierAdmin},
+		{3389, RiskTierAdmin},
+		{5432, RiskTierDB},
+		{6379, RiskTierDB},
+		{443, RiskTierWeb},
+		{8080, RiskTierWeb},
+		{25, RiskTierOther},
+		{65535, RiskTierOther},
+	}
+
+	for _, test := range tests {
+		if got := PortRiskTier(test.port); got != test.want {
+			t.Errorf("%d: expected %q, got %q", test.port, test.want, got)
+		}
+	}
+}
+
+func TestOrderByRiskTier(t *testing.T) {
+	got := orderByRiskTier([]int{25, 80, 22, 5432, 443, 3389, 1})
+
+	// The ports keep their order within a tier.
+	if want := []int{22, 3389, 5432, 80, 443, 25, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWithRiskTierOrder(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("25,80,6379,22"), WithRiskTierOrder())
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"-a", "10.0.0.1", "-p", "22,6379,80,25", "--", "-oX", "-"}; len(invocations) != 1 || !reflect.DeepEqual(invocations[0], want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	result, _, err := scanner.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tiers := make(map[uint16]string)
+	for _, port := range result.Hosts[0].Ports {
+		tiers[port.ID] = port.RiskTier
+	}
+	if want := map[uint16]string{22: RiskTierAdmin, 6379: RiskTierDB, 80: RiskTierWeb, 25: RiskTierOther}; !reflect.DeepEqual(tiers, want) {
+		t.Errorf("expected tiers %v, got %v", want, tiers)
+	}
+}
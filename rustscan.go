@@ -5,9 +5,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ScanRunner represents something that can run a scan.
@@ -15,387 +27,2348 @@ type ScanRunner interface {
 	Run() (result *Run, warnings []string, err error)
 }
 
-// Streamer constantly streams the stdout.
+// Streamer constantly streams the output of a scan, see WithStdoutStreamer and
+// WithStderrStreamer.
 type Streamer interface {
 	Write(d []byte) (int, error)
 	Bytes() []byte
 }
 
 // Scanner represents an RustScan scanner.
+//
+// Every scan starts a new RustScan process, which exits once nmap is done. RustScan has
+// no server or REPL mode that could take new targets, so there is no process to reuse
+// or pool between scans. Repeated scans of the same hosts reuse the Scanner instead.
 type Scanner struct {
-	cmd *exec.Cmd
+	// cmd is the last RustScan process started, and scanDone is closed once the scan
+	// that started it has ended, see Wait.
+	cmdMutex sync.Mutex
+	cmd      *exec.Cmd
+	scanDone chan struct{}
+
+	// running is 1 while a scan is in progress, a scanner runs one scan at a time.
+	running int32
 
 	args       []string
+	nmapArgs   []string
+	scriptArgs map[string]string
 	binaryPath string
 	ctx        context.Context
 
 	portFilter func(Port) bool
 	hostFilter func(Host) bool
-
-	stderr, stdout bufio.Scanner
+	portOrder  func([]int) []int
+
+	name     string
+	metadata map[string]string
+
+	appendOutput string
+	validator    func(*Run) error
+	maxOpenPorts int
+	pairGroups   []hostPortGroup
+	sortResults  bool
+	niceness     int
+
+	stdoutMaxBytes int64
+
+	checkpointPath     string
+	checkpointInterval time.Duration
+
+	retryBudget      int
+	eventWriter      io.Writer
+	strictPrivileges bool
+	perHostParallel  int
+	defaultPorts     string
+	riskTiers        bool
+	killGrace        time.Duration
+	minimalMemory    bool
+	forbidden        []*net.IPNet
+	geoIP            GeoIPProvider
+	scanID           string
+	addressFiles     []string
+	filterChain      *FilterChain
+	greppable        bool
+	fastParse        bool
+	scriptMode       ScriptMode
+	excluded         excludedAddresses
+	configPath       string
+	window           *scanWindow
+	mallocMinBatch   int
+	publisher        Publisher
+	cdnPortLimit     int
+	maxRuntime       time.Duration
+	stdoutStreamer   Streamer
+	stderrStreamer   Streamer
+
+	// backoffBase and backoffFactor give the timeout of each attempt of a scan, see
+	// WithTimeoutBackoff.
+	backoffBase   time.Duration
+	backoffFactor float64
+
+	// errs holds the errors of options that received invalid values.
+	errs []error
+
+	// stdout and stderr hold the output of the last scan, see GetStdout and GetStderr.
+	outputMutex    sync.Mutex
+	stdout, stderr bytes.Buffer
 }
 
+// defaultStdoutMaxBytes is the amount of output a scan may produce unless
+// WithStdoutMaxBytes says otherwise.
+const defaultStdoutMaxBytes = 256 << 20
+
 // Option is a function that is used for grouping of Scanner options.
 // Option adds or removes RustScan command line arguments.
 type Option func(*Scanner)
 
-// NewScanner creates a new Scanner, and can take options to apply to the scanner.
+// NewScanner creates a new Scanner, and can take options to apply to the scanner. It
+// returns the ValidationErrors of Validate when the options are invalid or conflict.
 func NewScanner(options ...Option) (*Scanner, error) {
-	scanner := &Scanner{}
+	scanner := &Scanner{
+		stdoutMaxBytes: defaultStdoutMaxBytes,
+	}
 
 	for _, option := range options {
 		option(scanner)
 	}
 
-	if scanner.binaryPath == "" {
-		var err error
-		scanner.binaryPath, err = exec.LookPath("rustscan")
-		if err != nil {
-			return nil, ErrRustScanNotInstalled
-		}
+	// Conflicting or invalid options are reported now rather than when a scan is run.
+	if err := scanner.Validate(); err != nil {
+		return nil, err
+	}
+
+	if scanner.binaryPath == "" {
+		var err error
+		scanner.binaryPath, err = exec.LookPath("rustscan")
+		if err != nil {
+			return nil, ErrRustScanNotInstalled
+		}
+	}
+
+	if scanner.configPath != "" {
+		if info, err := os.Stat(scanner.configPath); err != nil || info.IsDir() {
+			return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, scanner.configPath)
+		}
+	}
+
+	if scanner.ctx == nil {
+		scanner.ctx = context.Background()
+	}
+
+	return scanner, nil
+}
+
+// Run runs RustScan synchronously and returns the result of the scan.
+func (s *Scanner) Run() (result *Run, warnings []string, err error) {
+	return s.runScan(s.cdnPortLimit, nil)
+}
+
+// RunWithCDNLimit runs RustScan like Run, with the CDN limit given as an argument
+// rather than with WithCDNPortLimit.
+//
+// Deprecated: use WithCDNPortLimit and Run instead.
+func (s *Scanner) RunWithCDNLimit(limit int) (result *Run, warnings []string, err error) {
+	return s.runScan(limit, nil)
+}
+
+// RunWithProgress runs RustScan like Run, and reports the progress of the scan on the
+// given channel as a fraction between 0 and 1. The progress follows the phases of the
+// scan, RustScan does not tell how much of its port scan is done, and then the progress
+// nmap reports every few seconds (--stats-every) during its own stage. Values are
+// dropped rather than blocking the scan when the channel is not ready to receive them.
+// The channel is closed when RunWithProgress returns, whether the scan succeeded or
+// not, so that its consumer can range over it. A nil channel runs the scan like Run.
+func (s *Scanner) RunWithProgress(progress chan<- float32) (result *Run, warnings []string, err error) {
+	if progress == nil {
+		return s.Run()
+	}
+	defer close(progress)
+
+	report := func(value float32) {
+		select {
+		case progress <- value:
+		default:
+		}
+	}
+
+	result, warnings, err = s.runScan(s.cdnPortLimit, report)
+	if err == nil {
+		report(1)
+	}
+
+	return result, warnings, err
+}
+
+// AsyncResult is the outcome of a scan started with RunAsync, the values Run returns.
+type AsyncResult struct {
+	Run      *Run
+	Warnings []string
+	Err      error
+}
+
+// RunAsync starts RustScan like Run without waiting for the scan to finish. The outcome
+// of the scan is delivered on the returned channel, which is then closed. Cancelling
+// the context of the scanner stops the scan, which then delivers ErrScanTimeout.
+func (s *Scanner) RunAsync() <-chan AsyncResult {
+	done := make(chan AsyncResult, 1)
+
+	// The scan is started before RunAsync returns, so that Wait waits for it.
+	if !s.startScan() {
+		done <- AsyncResult{Err: ErrScanInProgress}
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+
+		result, warnings, err := s.runStarted(s.cdnPortLimit, nil)
+		done <- AsyncResult{Run: result, Warnings: warnings, Err: err}
+	}()
+
+	return done
+}
+
+// statsInterval is how often nmap reports its progress during RunWithProgress.
+const statsInterval = "2s"
+
+// phaseProgress is the progress reported when a scan enters each phase.
+var phaseProgress = map[string]float32{
+	PhasePortScan: 0,
+	PhaseScripts:  0.5,
+	PhaseNmap:     0.6,
+}
+
+// runScan runs every RustScan process of a scan, reporting its progress to the given
+// function unless it is nil.
+func (s *Scanner) runScan(limit int, progress func(float32)) (result *Run, warnings []string, err error) {
+	if !s.startScan() {
+		return nil, warnings, ErrScanInProgress
+	}
+
+	return s.runStarted(limit, progress)
+}
+
+// startScan marks a scan as in progress, unless one already is. The scanner keeps the
+// process and the output of its scan, so a second scan has to wait for the first to
+// end. The arguments of each scan are built anew by buildArgs.
+func (s *Scanner) startScan() bool {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return false
+	}
+
+	s.cmdMutex.Lock()
+	defer s.cmdMutex.Unlock()
+
+	s.cmd = nil
+	s.scanDone = make(chan struct{})
+
+	return true
+}
+
+// endScan marks the scan started by startScan as ended.
+func (s *Scanner) endScan() {
+	s.cmdMutex.Lock()
+	close(s.scanDone)
+	s.cmdMutex.Unlock()
+
+	atomic.StoreInt32(&s.running, 0)
+}
+
+// runStarted runs a scan once startScan marked it as in progress, see runScan.
+func (s *Scanner) runStarted(limit int, progress func(float32)) (result *Run, warnings []string, err error) {
+	defer s.endScan()
+
+	if err := s.Validate(); err != nil {
+		return nil, warnings, err
+	}
+
+	if s.window != nil && !s.window.contains(time.Now()) {
+		return nil, warnings, ErrOutsideScanWindow
+	}
+
+	if s.strictPrivileges {
+		if err := s.checkPrivileges(); err != nil {
+			return nil, warnings, err
+		}
+	}
+
+	scanID := s.scanID
+	if scanID == "" {
+		scanID = newScanID()
+	}
+
+	started := time.Now()
+	s.resetOutput()
+
+	// The scan is stopped when the context of the scanner is done, or once it has run for
+	// longer than WithMaxRuntime allows, whichever comes first.
+	ctx := s.ctx
+	if s.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.maxRuntime)
+		defer cancel()
+	}
+
+	defer func() {
+		if result != nil {
+			result.ScanID = scanID
+		}
+	}()
+
+	var events *eventStream
+	if s.eventWriter != nil {
+		events = newEventStream(s.eventWriter, scanID, s.name)
+		events.emit(ScanEvent{Type: EventScanStart})
+
+		defer func() {
+			end := ScanEvent{Type: EventScanEnd}
+			if result != nil {
+				end.Hosts = len(result.Hosts)
+			}
+			if err != nil {
+				end.Error = err.Error()
+			}
+			events.emit(end)
+
+			if events.err != nil {
+				warnings = append(warnings, events.err.Error())
+			}
+		}()
+	}
+
+	scan := &scanState{events: events}
+	if s.appendOutput != "" {
+		scan.hosts, err = openHostWriter(s.appendOutput)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("unable to append results to %s: %w", s.appendOutput, err)
+		}
+		// postProcess closes the file once the scan succeeded, this only closes it when
+		// the scan failed.
+		defer scan.hosts.close()
+	}
+
+	// A scan that times out reports how long it ran in total, not only its last process,
+	// including in its scan_end event.
+	defer func() {
+		var timeoutErr *ScanTimeoutError
+		if errors.As(err, &timeoutErr) {
+			timeoutErr.Elapsed = time.Since(started)
+		}
+	}()
+
+	invocations, err := s.invocations()
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	if progress != nil {
+		for idx, args := range invocations {
+			invocations[idx] = withStatsEvery(args)
+		}
+	}
+
+	for _, args := range invocations {
+		if excludesAllPorts(args) {
+			warnings = append(warnings, "every port to scan is excluded with WithExcludePorts, nothing will be scanned")
+			break
+		}
+	}
+
+	// A single checkpoint covers every process of the scan, so the open ports found by
+	// each of them accumulate in it.
+	if s.checkpointPath != "" {
+		scan.checkpoint = newCheckpointer(s.checkpointPath, s.checkpointInterval, invocations)
+		if err := scan.checkpoint.start(); err != nil {
+			return nil, warnings, fmt.Errorf("unable to write checkpoint: %w", err)
+		}
+
+		defer func() {
+			if stopErr := scan.checkpoint.stop(err == nil); stopErr != nil {
+				warnings = append(warnings, fmt.Sprintf("unable to write checkpoint: %v", stopErr))
+			}
+		}()
+	}
+
+	var runs []*Run
+	if s.perHostParallel > 0 {
+		var runWarnings []string
+		runs, runWarnings, err = s.runParallel(ctx, invocations, limit, scan, progress)
+		warnings = append(warnings, runWarnings...)
+		if err != nil {
+			return nil, warnings, err
+		}
+	} else {
+		runs = make([]*Run, 0, len(invocations))
+		for idx, args := range invocations {
+			var runProgress func(float32)
+			if progress != nil {
+				// Each process accounts for an equal share of the progress.
+				offset, share := float32(idx)/float32(len(invocations)), 1/float32(len(invocations))
+				runProgress = func(value float32) {
+					progress(offset + value*share)
+				}
+			}
+
+			result, runWarnings, err := s.runAttempts(ctx, args, limit, scan, runProgress)
+			warnings = append(warnings, runWarnings...)
+			if err != nil {
+				return result, warnings, err
+			}
+
+			scan.checkpoint.complete(idx)
+			runs = append(runs, result)
+		}
+	}
+
+	result = runs[0]
+	if len(runs) > 1 {
+		result = MergeRuns(runs...)
+	}
+
+	result, warnings, err = s.postProcess(result, warnings, scan)
+	if err == nil {
+		events.hostsDone(result)
+
+		if s.publisher != nil {
+			warnings = append(warnings, publish(result, s.publisher, scanID, s.name)...)
+		}
+
+		// The scan went through with a reduced batch size, which the caller may want to
+		// avoid next time by raising the limit.
+		result.UlimitWarning = findUlimitWarning(warnings)
+	}
+
+	return result, warnings, err
+}
+
+// scanState is what the RustScan processes of a scan share.
+type scanState struct {
+	// events receives the events of the scan, see WithEventStreamJSON.
+	events *eventStream
+	// hosts appends the hosts to a file as they are decoded, see WithAppendOutputFile.
+	hosts *hostWriter
+	// checkpoint records the progress of the scan, see WithCheckpoint.
+	checkpoint *checkpointer
+}
+
+// run runs a single RustScan process with the given arguments and parses its output.
+func (s *Scanner) run(ctx context.Context, args []string, limit int, scan *scanState, progress func(float32)) (result *Run, warnings []string, err error) {
+	var stderr bytes.Buffer
+
+	name := s.binaryPath
+	if s.niceness != 0 {
+		var niceErr error
+		name, args, niceErr = niceCommand(s.niceness, name, args)
+		if niceErr != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to set the niceness of the scan: %v", niceErr))
+		}
+	}
+
+	// Prepare RustScan process
+	cmd := exec.Command(name, args...)
+	// Kill the nmap processes RustScan spawns along with it when the scan is stopped.
+	setProcessGroup(cmd)
+
+	cmdStdoutPipe, _ := cmd.StdoutPipe()
+
+	//cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	var budgetExceeded int32
+	if s.retryBudget > 0 {
+		cmd.Stderr = io.MultiWriter(&stderr, &retryCounter{
+			budget: s.retryBudget,
+			exceeded: func() {
+				atomic.StoreInt32(&budgetExceeded, 1)
+				_ = killProcess(cmd.Process)
+			},
+		})
+	}
+
+	stdoutTee := newStreamTee(s.stdoutStreamer)
+	stderrTee := newStreamTee(s.stderrStreamer)
+	if stderrTee != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stderrTee)
+	}
+
+	defer func() {
+		if dropped := stdoutTee.close(); dropped > 0 {
+			warnings = append(warnings, fmt.Sprintf("stdout streamer fell behind, %d bytes of output were not streamed", dropped))
+		}
+		if dropped := stderrTee.close(); dropped > 0 {
+			warnings = append(warnings, fmt.Sprintf("stderr streamer fell behind, %d bytes of output were not streamed", dropped))
+		}
+	}()
+
+	// Run RustScan process
+	err = cmd.Start()
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	s.setProcess(cmd)
+
+	// Stop the process as soon as the context is done, even while its output is read.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stopProcess(cmd.Process, finished)
+		case <-finished:
+		}
+	}()
+
+	// nmap's XML output is decoded as RustScan prints it, rather than once the scan
+	// ends, so that the whole output does not have to be kept in memory.
+	var stream *xmlStream
+	if s.runsNmap() {
+		targets, _, _ := extractTargets(args)
+		stream = &xmlStream{lean: s.minimalMemory, targets: targets}
+		if scan.hosts != nil {
+			stream.onHost = func(host Host) {
+				scan.hosts.write(s.filterHosts(host)...)
+			}
+		}
+		defer stream.abort()
+	}
+
+	// The hosts decoded from nmap's XML output are appended as they are decoded, those
+	// of a result built otherwise once it is known.
+	defer func() {
+		if result != nil && (stream == nil || !stream.started()) {
+			scan.hosts.write(s.filterHosts(result.Hosts...)...)
+		}
+	}()
+
+	var (
+		total  int64
+		lines  lineBuffer
+		found  []openPort
+		phases = []Phase{{Name: PhasePortScan, At: time.Now()}}
+
+		nmapProgress float32
+		ulimitLines  []string
+		portBuffer   []openPort
+
+		// output is RustScan's own output, which precedes nmap's XML output.
+		output bytes.Buffer
+	)
+	defer func() {
+		s.recordOutput(output.Bytes(), stderr.Bytes())
+	}()
+	if progress != nil {
+		progress(phaseProgress[PhasePortScan])
+	}
+
+	// 从管道中实时获取输出并打印到终端
+	tmp := make([]byte, 1024)
+	for {
+		read, err := cmdStdoutPipe.Read(tmp)
+
+		total += int64(read)
+		if s.stdoutMaxBytes > 0 && total > s.stdoutMaxBytes {
+			_ = killProcess(cmd.Process)
+			_ = cmd.Wait()
+			return nil, warnings, ErrOutputTooLarge
+		}
+
+		// Only the bytes that were read are valid, the rest of the buffer is left over
+		// from previous reads.
+		_, _ = stdoutTee.Write(tmp[:read])
+		for _, line := range lines.write(string(tmp[:read])) {
+			if stream != nil {
+				stream.feed(line)
+			}
+			if stream == nil || !stream.started() {
+				output.WriteString(line)
+				output.WriteByte('\n')
+			}
+
+			if phase, ok := parsePhase(line); ok {
+				phases = append(phases, Phase{Name: phase, At: time.Now()})
+				if progress != nil {
+					progress(phaseProgress[phase])
+				}
+			}
+
+			if warning, _, ok := parseUlimitWarning(line); ok {
+				ulimitLines = append(ulimitLines, warning)
+			}
+
+			if percent, ok := parseTaskProgress(line); ok && progress != nil {
+				// nmap reports the progress of each of its tasks in turn, the overall
+				// progress only follows the furthest one so that it never goes back.
+				if value := phaseProgress[PhaseNmap] + (1-phaseProgress[PhaseNmap])*percent/100; value > nmapProgress {
+					nmapProgress = value
+					progress(value)
+				}
+			}
+
+			var ports []openPort
+			switch {
+			case s.fastParse:
+				// The ports of a line are only needed until they are added to found.
+				ports = portBuffer[:0]
+				if port, ok := parseOpenLineFast(line); ok {
+					ports = append(ports, port)
+				} else if s.greppable {
+					ports, _, _ = parseGreppableLineFast(ports, line)
+				}
+				portBuffer = ports
+			default:
+				if port, ok := parseOpenLine(line); ok {
+					ports = append(ports, port)
+				} else if s.greppable {
+					ports, _, _ = parseGreppableLine(line)
+				}
+			}
+
+			for _, port := range ports {
+				found = append(found, port)
+				scan.events.emit(ScanEvent{Type: EventPortOpen, Address: port.addr, Port: port.port})
+				scan.checkpoint.add(port)
+			}
+		}
+
+		if s.maxOpenPorts > 0 && len(found) >= s.maxOpenPorts {
+			// Enough open ports were found, the rest of the scan is not needed.
+			_ = killProcess(cmd.Process)
+			_ = cmd.Wait()
+
+			result := openPortsRun(found[:s.maxOpenPorts])
+			result.Phases = phases
+			s.annotate(result)
+			return result, warnings, nil
+		}
+
+		if limit > 0 && len(found) > limit {
+			// A target answering on that many ports is most likely a CDN, the rest of
+			// the scan would not tell anything useful.
+			_ = killProcess(cmd.Process)
+			_ = cmd.Wait()
+			return nil, warnings, &CDNError{OpenPorts: len(found), Limit: limit}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// Wait for RustScan process, which the watcher stops if the context is done.
+	_ = cmd.Wait()
+
+	if ctx.Err() != nil {
+		// Context was done before the scan was finished.
+		// A timeout error is returned, along with what the scan found when the
+		// process was given a grace period to exit cleanly.
+		timeoutErr := &ScanTimeoutError{Elapsed: time.Since(phases[0].At), OpenPorts: len(found)}
+		if s.killGrace > 0 {
+			return s.partialResult(stream, found, phases), warnings, timeoutErr
+		}
+		return nil, warnings, timeoutErr
+	}
+
+	if atomic.LoadInt32(&budgetExceeded) == 1 {
+		return nil, warnings, ErrRetryBudgetExceeded
+	}
+
+	// Process RustScan stderr output containing none-critical errors and warnings
+	// Everyone needs to check whether one or some of these warnings is a hard issue in their use case
+	if stderr.Len() > 0 {
+		warnings = append(warnings, strings.Split(strings.Trim(stderr.String(), "\n"), "\n")...)
+	}
+
+	// RustScan prints its warnings about the open file limit on stdout, they are
+	// reported with the others.
+	warnings = append(warnings, ulimitLines...)
+
+	// Check for warnings that will inevitably lead to parsing errors, hence, have priority.
+	if err := analyzeWarnings(warnings); err != nil {
+		return nil, warnings, err
+	}
+
+	// Parse RustScan xml output. Usually RustScan always returns valid XML, even if there is a scan error.
+	// Potentially available warnings are returned too, but probably not the reason for a broken XML.
+
+	switch {
+	case !s.runsNmap():
+		// Without nmap, the open ports RustScan reported as it ran are all there is,
+		// like ParseGreppable does with greppable lines.
+		result = openPortsRun(found)
+	default:
+		if lines.pending != "" {
+			stream.feed(lines.pending)
+		}
+		if !stream.started() && s.scriptMode == ScriptsCustom && len(found) > 0 {
+			// The custom scripts did not print any nmap XML output.
+			result = openPortsRun(found)
+			break
+		}
+		result, err = stream.close()
+	}
+	if err != nil {
+		warnings = append(warnings, err.Error()) // Append parsing error to warnings for those who are interested.
+		return nil, warnings, ErrParseOutput
+	}
+
+	result.Phases = phases
+	s.annotate(result)
+
+	// Critical scan errors are reflected in the XML.
+	if result != nil && len(result.Stats.Finished.ErrorMsg) > 0 {
+		return result, warnings, nmapError(result.Stats.Finished.ErrorMsg)
+	}
+
+	return result, warnings, nil
+}
+
+// knownNmapErrors maps the messages nmap reports in its XML output when it fails to the
+// error they are reported as.
+var knownNmapErrors = []struct {
+	message string
+	err     error
+}{
+	{"Error resolving name", ErrResolveName},
+	{"Failed to resolve", ErrResolveName},
+	{"dnet: Failed to open device", ErrPermissionDenied},
+	{"requires root privileges", ErrPermissionDenied},
+	{"caught SIG", ErrNmapInterrupted},
+	{"interrupted", ErrNmapInterrupted},
+}
+
+// nmapError returns the error to report for the error message of nmap's XML output.
+// Unknown messages are reported as ErrNmapFailed along with the message.
+func nmapError(message string) error {
+	for _, known := range knownNmapErrors {
+		if strings.Contains(message, known.message) {
+			return known.err
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrNmapFailed, message)
+}
+
+// extractXML returns nmap's XML output from RustScan's output. The XML is located by
+// its declaration rather than by the "[~]" prefix RustScan prints before it, which it
+// leaves out in accessible mode.
+func extractXML(output string) []byte {
+	if start := strings.Index(output, "<?xml "); start >= 0 {
+		out := output[start:]
+		if end := strings.LastIndex(out, "</nmaprun>"); end >= 0 {
+			out = out[:end+len("</nmaprun>")]
+		}
+		return []byte(out)
+	}
+
+	return nil
+}
+
+// partialResult recovers what a scan that was stopped found: nmap's XML output if it
+// was flushed completely, the open ports RustScan reported otherwise. It returns nil
+// when the scan found nothing.
+func (s *Scanner) partialResult(stream *xmlStream, found []openPort, phases []Phase) *Run {
+	var xml []byte
+	if stream != nil {
+		xml = stream.rawXML()
+	}
+
+	result, err := Parse(xml)
+	if err != nil {
+		if len(found) == 0 {
+			return nil
+		}
+		result = openPortsRun(found)
+	}
+
+	result.Phases = phases
+	s.annotate(result)
+
+	return result
+}
+
+// stopProcess stops a scan process: it asks the process to exit and gives it the grace
+// period set with WithKillGracePeriod to do so, then kills it. It returns early once
+// finished is closed.
+func (s *Scanner) stopProcess(process *os.Process, finished <-chan struct{}) {
+	if s.killGrace > 0 && terminate(process) == nil {
+		timer := time.NewTimer(s.killGrace)
+		defer timer.Stop()
+
+		select {
+		case <-finished:
+			return
+		case <-timer.C:
+		}
+	}
+
+	_ = killProcess(process)
+}
+
+// annotate copies the information the scanner attaches to its results onto result.
+func (s *Scanner) annotate(result *Run) {
+	result.Name = s.name
+
+	if len(s.metadata) > 0 {
+		result.Metadata = make(map[string]string, len(s.metadata))
+		for key, value := range s.metadata {
+			result.Metadata[key] = value
+		}
+	}
+}
+
+// postProcess applies the filters, the validator and the outputs of the scanner to a
+// parsed result.
+func (s *Scanner) postProcess(result *Run, warnings []string, scan *scanState) (*Run, []string, error) {
+	if s.riskTiers {
+		tagRiskTiers(result)
+	}
+	if s.geoIP != nil {
+		warnings = append(warnings, enrichGeo(result, s.geoIP)...)
+	}
+
+	// Call filters if they are set.
+	if s.portFilter != nil {
+		result = choosePorts(result, s.portFilter)
+	}
+	if s.hostFilter != nil {
+		result = chooseHosts(result, s.hostFilter)
+	}
+	if len(s.excluded.specs) > 0 {
+		// nmap leaves the excluded hosts out, but RustScan alone does not.
+		result = chooseHosts(result, func(host Host) bool {
+			return !s.excluded.contains(host)
+		})
+	}
+	if s.filterChain != nil {
+		s.filterChain.Apply(result)
+	}
+
+	if s.sortResults {
+		sortResult(result)
+	}
+
+	if s.validator != nil {
+		if err := s.validator(result); err != nil {
+			return result, warnings, fmt.Errorf("scan result rejected by validator: %w", err)
+		}
+	}
+
+	// The hosts were appended to the file as they were decoded, only the file is left
+	// to close.
+	if err := scan.hosts.close(); err != nil {
+		return result, warnings, fmt.Errorf("unable to append results to %s: %w", s.appendOutput, err)
+	}
+
+	// Return result, optional warnings but no error
+	return result, warnings, nil
+}
+
+// filterHosts applies the filters of postProcess that concern each host on its own,
+// for hosts written before the result of the scan is complete.
+func (s *Scanner) filterHosts(hosts ...Host) []Host {
+	result := &Run{Hosts: make([]Host, 0, len(hosts))}
+	for _, host := range hosts {
+		host.Ports = append([]Port(nil), host.Ports...)
+		result.Hosts = append(result.Hosts, host)
+	}
+
+	if s.riskTiers {
+		tagRiskTiers(result)
+	}
+	if s.portFilter != nil {
+		result = choosePorts(result, s.portFilter)
+	}
+	if s.hostFilter != nil {
+		result = chooseHosts(result, s.hostFilter)
+	}
+	if len(s.excluded.specs) > 0 {
+		result = chooseHosts(result, func(host Host) bool {
+			return !s.excluded.contains(host)
+		})
+	}
+	if s.filterChain != nil {
+		s.filterChain.Apply(result)
+	}
+
+	return result.Hosts
+}
+
+// invocations returns the command lines of the RustScan processes a scan consists of.
+// Most scans need a single process, host:port pairs need one per group of hosts
+// sharing the same ports, and ports too many for a command line are split across
+// several, see splitPortArgs.
+func (s *Scanner) invocations() ([][]string, error) {
+	if len(s.pairGroups) == 0 {
+		return s.buildInvocations()
+	}
+
+	var invocations [][]string
+	for _, group := range s.pairGroups {
+		groupInvocations, err := s.buildInvocations("-a", strings.Join(group.hosts, ","), "-p", formatPorts(group.ports))
+		if err != nil {
+			return nil, err
+		}
+
+		invocations = append(invocations, groupInvocations...)
+	}
+
+	return invocations, nil
+}
+
+// buildInvocations returns the command lines of the processes scanning with the
+// scanner's options and the extra arguments.
+func (s *Scanner) buildInvocations(extra ...string) ([][]string, error) {
+	args, err := s.buildArgs(extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	split, err := splitPortArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var invocations [][]string
+	for _, args := range split {
+		// The excluded ports only have to be within the ports of each process.
+		args, err = normalizeExcludeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+
+		args = s.withNmapArgs(args)
+		if s.perHostParallel > 0 {
+			invocations = append(invocations, splitTargets(args)...)
+		} else {
+			invocations = append(invocations, args)
+		}
+	}
+
+	return invocations, nil
+}
+
+// buildArgs assembles the RustScan arguments of a scan from the scanner's options and
+// the extra arguments, without modifying the arguments stored on the scanner. The
+// ports are left as a -p specification for splitPortArgs, and the arguments of the
+// nmap stage for withNmapArgs.
+func (s *Scanner) buildArgs(extra ...string) ([]string, error) {
+	// The extra arguments are RustScan's, they go before the nmap arguments given after
+	// "--" with WithCustomArguments.
+	own, nmap := splitNmapArgs(s.args)
+	args := make([]string, 0, len(s.args)+len(extra))
+	args = append(args, own...)
+	args = append(args, extra...)
+
+	if s.defaultPorts != "" && !containsString(args, "-p") && !containsString(args, "-r") && !containsString(args, "--top") {
+		args = append(args, "-p", s.defaultPorts)
+	}
+	args = append(args, nmap...)
+
+	// Targets set by several options are passed as a single list.
+	if targets, rest, at := extractTargets(args); at >= 0 {
+		args = insertTargets(rest, at, targets)
+	}
+
+	args, err := s.filterForbidden(args)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err = s.excluded.filterTargets(args)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err = normalizePortArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.portOrder != nil {
+		args, err = orderPorts(args, s.portOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
+
+// withNmapArgs appends the arguments of the nmap stage to RustScan's arguments.
+func (s *Scanner) withNmapArgs(args []string) []string {
+	if !containsString(args, "--resume") && s.runsNmap() {
+		// The arguments given after "--" with WithCustomArguments already start the
+		// ones of nmap, which a second "--" would end.
+		if !containsString(args, "--") {
+			args = append(args, "--")
+		}
+		// Arguments for the nmap stage RustScan runs after its port scan
+		args = append(args, s.nmapArgs...)
+		if len(s.excluded.specs) > 0 {
+			args = append(args, "--exclude", strings.Join(s.excluded.specs, ","))
+		}
+		if len(s.scriptArgs) > 0 {
+			args = append(args, "--script-args", formatScriptArgs(s.scriptArgs))
+		}
+		// Enable XML output
+		args = append(args, "-oX")
+		// Get XML output in stdout instead of writing it in a file
+		args = append(args, "-")
+	}
+
+	return args
+}
+
+// withStatsEvery makes nmap report its progress periodically, unless the arguments
+// already set how often, or do not run nmap.
+func withStatsEvery(args []string) []string {
+	if containsString(args, "--stats-every") {
+		return args
+	}
+
+	for idx, arg := range args {
+		if arg == "--" {
+			withStats := make([]string, 0, len(args)+2)
+			withStats = append(withStats, args[:idx+1]...)
+			withStats = append(withStats, "--stats-every", statsInterval)
+			return append(withStats, args[idx+1:]...)
+		}
+	}
+
+	return args
+}
+
+// Wait waits for the last scan the scanner started to end, such as one started with
+// RunAsync, and returns the exit error of its last RustScan process if it failed. It
+// returns ErrNoActiveScan when no scan was started yet.
+func (s *Scanner) Wait() error {
+	s.cmdMutex.Lock()
+	done := s.scanDone
+	s.cmdMutex.Unlock()
+
+	if done == nil {
+		return ErrNoActiveScan
+	}
+
+	<-done
+
+	s.cmdMutex.Lock()
+	cmd := s.cmd
+	s.cmdMutex.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	if state := cmd.ProcessState; state != nil && !state.Success() {
+		return &exec.ExitError{ProcessState: state}
+	}
+
+	return nil
+}
+
+// setProcess records a started RustScan process for Wait.
+func (s *Scanner) setProcess(cmd *exec.Cmd) {
+	s.cmdMutex.Lock()
+	defer s.cmdMutex.Unlock()
+
+	s.cmd = cmd
+}
+
+// GetStdout returns a scanner over the lines RustScan printed on stdout during the last
+// scan, that of every process for a scan running several. nmap's XML output is left out,
+// see Run.WriteXML for it.
+func (s *Scanner) GetStdout() bufio.Scanner {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+
+	return *bufio.NewScanner(bytes.NewReader(append([]byte(nil), s.stdout.Bytes()...)))
+}
+
+// GetStderr returns a scanner over the lines RustScan printed on stderr during the last
+// scan, that of every process for a scan running several.
+func (s *Scanner) GetStderr() bufio.Scanner {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+
+	return *bufio.NewScanner(bytes.NewReader(append([]byte(nil), s.stderr.Bytes()...)))
+}
+
+// resetOutput forgets the output of the previous scan, when a new one starts.
+func (s *Scanner) resetOutput() {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+
+	s.stdout.Reset()
+	s.stderr.Reset()
+}
+
+// recordOutput adds the output of a RustScan process to that of the scan.
+func (s *Scanner) recordOutput(stdout, stderr []byte) {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+
+	s.stdout.Write(stdout)
+	s.stderr.Write(stderr)
+}
+
+// AddOptions sets more scan options after the scan is created.
+func (s *Scanner) AddOptions(options ...Option) {
+	for _, option := range options {
+		option(s)
+	}
+}
+
+func chooseHosts(result *Run, filter func(Host) bool) *Run {
+	var filteredHosts []Host
+
+	for _, host := range result.Hosts {
+		if filter(host) {
+			filteredHosts = append(filteredHosts, host)
+		}
+	}
+
+	result.Hosts = filteredHosts
+
+	return result
+}
+
+func choosePorts(result *Run, filter func(Port) bool) *Run {
+	for idx := range result.Hosts {
+		var filteredPorts []Port
+
+		for _, port := range result.Hosts[idx].Ports {
+			if filter(port) {
+				filteredPorts = append(filteredPorts, port)
+			}
+		}
+
+		result.Hosts[idx].Ports = filteredPorts
+	}
+
+	return result
+}
+
+// sortResult sorts the hosts of a result by address and their ports by number.
+func sortResult(result *Run) {
+	sort.SliceStable(result.Hosts, func(i, j int) bool {
+		return lessAddress(hostKey(result.Hosts[i]), hostKey(result.Hosts[j]))
+	})
+
+	for idx := range result.Hosts {
+		ports := result.Hosts[idx].Ports
+		sort.SliceStable(ports, func(i, j int) bool {
+			if ports[i].ID != ports[j].ID {
+				return ports[i].ID < ports[j].ID
+			}
+			return ports[i].Protocol < ports[j].Protocol
+		})
+	}
+}
+
+// lessAddress orders IP addresses numerically, before hostnames which are ordered
+// alphabetically.
+func lessAddress(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+
+	switch {
+	case ipA != nil && ipB != nil:
+		return bytes.Compare(ipA.To16(), ipB.To16()) < 0
+	case ipA != nil:
+		return true
+	case ipB != nil:
+		return false
+	default:
+		return a < b
+	}
+}
+
+func analyzeWarnings(warnings []string) error {
+	// Check for warnings that will inevitably lead to parsing errors, hence, have priority.
+	for _, known := range knownErrors {
+		for _, warning := range warnings {
+			if strings.Contains(warning, known.message) {
+				return known.err
+			}
+		}
+	}
+	return nil
+}
+
+// knownErrors maps the messages of RustScan and nmap that mean the scan failed to the
+// error they are reported as, in order of priority.
+var knownErrors = []struct {
+	message string
+	err     error
+}{
+	{"Malloc Failed!", ErrMallocFailed},
+	{"requires root privileges", ErrPermissionDenied},
+	{"Operation not permitted", ErrPermissionDenied},
+	{"Permission denied (os error 13)", ErrPermissionDenied},
+	{"No targets were specified", ErrNoTargets},
+	{"No IPs could be resolved", ErrNoTargets},
+	{"panicked at", ErrRustScanPanic},
+	{"stack backtrace:", ErrRustScanPanic},
+}
+
+// findUlimitWarning returns the warning of RustScan about the open file limit among the
+// warnings, nil if there is none. When there are several, such as for a scan running
+// several processes, the one suggesting the highest limit is kept.
+func findUlimitWarning(warnings []string) *UlimitWarning {
+	var found *UlimitWarning
+	for _, warning := range warnings {
+		if message, suggested, ok := parseUlimitWarning(warning); ok {
+			if found == nil || suggested > found.Suggested {
+				found = &UlimitWarning{Suggested: suggested, Message: message}
+			}
+		}
+	}
+
+	return found
+}
+
+// WithContext adds a context to a scanner, to make it cancellable and able to timeout.
+func WithContext(ctx context.Context) Option {
+	return func(s *Scanner) {
+		s.ctx = ctx
+	}
+}
+
+// WithMaxRuntime stops every scan that runs for longer than d, as a context with a
+// timeout given to WithContext would, and Run then returns a ScanTimeoutError. Unlike
+// that context, the time is counted from the start of each scan rather than once for
+// the scanner. When both are set, the scan is stopped by whichever is done first: the
+// context given to WithContext, or d after the scan started.
+func WithMaxRuntime(d time.Duration) Option {
+	return func(s *Scanner) {
+		if d <= 0 {
+			s.errs = append(s.errs, fmt.Errorf("invalid maximum runtime %v", d))
+			return
+		}
+
+		s.maxRuntime = d
+	}
+}
+
+// WithBinaryPath sets the RustScan binary path for a scanner.
+func WithBinaryPath(binaryPath string) Option {
+	return func(s *Scanner) {
+		s.binaryPath = binaryPath
+	}
+}
+
+// WithCustomArguments sets custom arguments to give to the RustScan binary.
+// There should be no reason to use this, unless you are using a custom build
+// of RustScan or that this repository isn't up to date with the latest options
+// of the official RustScan release.
+// You can use this as a quick way to paste an RustScan command into your go code,
+// but remember that the whole purpose of this repository is to be idiomatic,
+// provide type checking, enums for the values that can be passed, etc.
+func WithCustomArguments(args ...string) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, args...)
+	}
+}
+
+// WithFilterPort allows to set a custom function to filter out ports that
+// don't fulfill a given condition. When the given function returns true,
+// the port is kept, otherwise it is removed from the result. Can be used
+// along with WithFilterHost.
+func WithFilterPort(portFilter func(Port) bool) Option {
+	return func(s *Scanner) {
+		s.portFilter = portFilter
+	}
+}
+
+// WithFilterHost allows to set a custom function to filter out hosts that
+// don't fulfill a given condition. When the given function returns true,
+// the host is kept, otherwise it is removed from the result. Can be used
+// along with WithFilterPort.
+func WithFilterHost(hostFilter func(Host) bool) Option {
+	return func(s *Scanner) {
+		s.hostFilter = hostFilter
+	}
+}
+
+// WithFilterChain applies the stages of a filter chain in order to the result of each
+// scan, after the filters set with WithFilterPort and WithFilterHost. For instance, a
+// chain keeping open ports, then web ports, then the hosts left with a port:
+//
+//	chain := NewFilterChain().
+//		FilterPorts("open", func(p Port) bool { return p.Status() == Open }).
+//		FilterPorts("web", func(p Port) bool { return p.ID == 80 || p.ID == 443 }).
+//		FilterHosts("exposed", func(h Host) bool { return len(h.Ports) > 0 }).
+//		RecordStats()
+func WithFilterChain(chain *FilterChain) Option {
+	return func(s *Scanner) {
+		s.filterChain = chain
+	}
+}
+
+// WithResultValidator sets a function that inspects the result once it is parsed and
+// filtered. When the function returns an error, Run returns the result along with that
+// error wrapped, for instance to reject a scan in which every host exposes the exact same
+// ports, which usually means a CDN or a WAF answered for all of them.
+func WithResultValidator(validator func(*Run) error) Option {
+	return func(s *Scanner) {
+		s.validator = validator
+	}
+}
+
+// WithAbortOnFirstOpenPort stops the scan as soon as RustScan reports an open port and
+// returns a result holding only that port, which is enough to tell whether anything is
+// open on the targets. The nmap stage never runs, so the port has no service information.
+func WithAbortOnFirstOpenPort() Option {
+	return WithMaxOpenPorts(1)
+}
+
+// WithCDNPortLimit stops a scan with ErrScanCDN once RustScan reports more than limit
+// open ports, before nmap runs: a target answering on that many ports is most likely a
+// CDN or a firewall accepting every connection, and its results would tell nothing
+// useful. A limit of 0, the default, disables the check. With WithPerHostParallel or
+// WithHostPortPairs, the limit applies to each RustScan process.
+func WithCDNPortLimit(limit int) Option {
+	return func(s *Scanner) {
+		if limit < 0 {
+			s.errs = append(s.errs, fmt.Errorf("invalid CDN port limit %d", limit))
+			return
+		}
+
+		s.cdnPortLimit = limit
+	}
+}
+
+// WithMaxOpenPorts caps the findings of a scan: once RustScan reports n open ports, the
+// scan stops and Run returns a result holding these ports, without an error. Unlike the
+// limit of WithCDNPortLimit, which aborts suspected CDNs with ErrScanCDN, reaching the cap is a normal
+// outcome. The nmap stage does not run when the cap is reached, so the ports have no
+// service information. With WithPerHostParallel or WithHostPortPairs, the cap applies
+// to each RustScan process.
+func WithMaxOpenPorts(n int) Option {
+	return func(s *Scanner) {
+		if n < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid maximum number of open ports %d", n))
+			return
+		}
+
+		s.maxOpenPorts = n
+	}
+}
+
+// WithSortedResults sorts the hosts of the result by address and the ports of each host
+// by number, so that the output of a scan is deterministic and easy to diff.
+func WithSortedResults() Option {
+	return func(s *Scanner) {
+		s.sortResults = true
+	}
+}
+
+// WithNiceness runs the scan with the given niceness, from -20 (highest priority) to
+// 19 (lowest priority), to lower its CPU priority on shared hosts. The scan is started
+// through nice(1), so that the nmap process spawned by RustScan inherits the priority.
+// Raising the priority requires privileges. On platforms without process niceness, the
+// option has no effect and Run returns a warning.
+func WithNiceness(niceness int) Option {
+	return func(s *Scanner) {
+		s.niceness = niceness
+	}
+}
+
+// WithPublisher publishes the result of a scan to a message broker once it is parsed:
+// a HostMessage on TopicHost for every host and a PortMessage on TopicPort for every
+// port, encoded as JSON. A message that cannot be published does not fail the scan, it
+// is reported in the warnings.
+func WithPublisher(publisher Publisher) Option {
+	return func(s *Scanner) {
+		s.publisher = publisher
+	}
+}
+
+// WithStdoutStreamer copies the output of RustScan to streamer as it is printed, while
+// Run still parses it. The output is copied from a goroutine of its own so that a slow
+// streamer does not stall the scan: when it falls too far behind, the output it could not
+// keep up with is not copied and Run reports it in the warnings. Run does not wait for
+// streamer to catch up before returning.
+func WithStdoutStreamer(streamer Streamer) Option {
+	return func(s *Scanner) {
+		s.stdoutStreamer = streamer
+	}
+}
+
+// WithStderrStreamer copies the error output of RustScan to streamer as it is printed,
+// the way WithStdoutStreamer does for its output. Run still returns it in the warnings.
+func WithStderrStreamer(streamer Streamer) Option {
+	return func(s *Scanner) {
+		s.stderrStreamer = streamer
+	}
+}
+
+// WithScanID sets the identifier of the scans of the scanner, the key correlating
+// them across systems such as logs, metrics or a tracing backend. It is set as
+// Run.ScanID on the results and included in every event written by WithEventStreamJSON.
+// Without it, every scan gets a random UUID of its own.
+func WithScanID(id string) Option {
+	return func(s *Scanner) {
+		s.scanID = id
+	}
+}
+
+// WithScanName labels the scanner with a name, which is copied onto the Run it returns.
+func WithScanName(name string) Option {
+	return func(s *Scanner) {
+		s.name = name
+	}
+}
+
+// WithStdoutMaxBytes caps the amount of output a RustScan process may write to stdout.
+// The output is read line by line as it is written, with nmap's XML output decoded as it
+// arrives, but the lines RustScan prints and the raw XML are still kept for GetStdout
+// and Run.WriteXML, so a runaway process would grow the memory of the scan without
+// bound. Once the cap is exceeded, the process is killed and Run returns
+// ErrOutputTooLarge. It defaults to 256 MiB, and a value of zero or less removes the cap.
+func WithStdoutMaxBytes(n int64) Option {
+	return func(s *Scanner) {
+		s.stdoutMaxBytes = n
+	}
+}
+
+// WithCheckpoint periodically saves the progress of the scan to the file at path, every
+// interval and once more when the scan ends, so that a crashed scan can be resumed from
+// it. See Checkpoint and ReadCheckpoint. A non-positive interval defaults to 10 seconds.
+func WithCheckpoint(path string, interval time.Duration) Option {
+	return func(s *Scanner) {
+		s.checkpointPath = path
+		s.checkpointInterval = interval
+	}
+}
+
+// WithPerHostParallel scans each target host with its own RustScan process, running at
+// most n of them at once, and merges their results into a single Run. Failures are
+// isolated: a host whose scan fails, for instance because it looks like a CDN, is
+// reported among the warnings while the others are still scanned, and Run only fails
+// when every host failed. Progress is then reported as the share of hosts scanned. It
+// cannot be combined with WithCheckpoint.
+func WithPerHostParallel(n int) Option {
+	return func(s *Scanner) {
+		if n < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid per host parallelism %d", n))
+			return
+		}
+
+		s.perHostParallel = n
+	}
+}
+
+// WithNoConfig makes RustScan ignore its configuration file, ~/.rustscan.toml
+// (--no-config), so that a scan only depends on the options of the scanner.
+func WithNoConfig() Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "--no-config")
+	}
+}
+
+// WithConfigPath makes RustScan read its configuration from the file at path instead
+// of ~/.rustscan.toml (--config-path), to keep separate profiles for different kinds of
+// scans. NewScanner returns ErrConfigNotFound when the file does not exist. It cannot be
+// combined with WithNoConfig.
+func WithConfigPath(path string) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "--config-path", path)
+		s.configPath = path
+	}
+}
+
+// WithAccessible runs RustScan in accessible mode (--accessible), which makes its
+// output friendlier to screen readers and other tools: no banner, ASCII art or colors.
+// The result is parsed the same way.
+func WithAccessible() Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "--accessible")
+	}
+}
+
+// WithGreppable runs RustScan in greppable mode (-g), which only discovers open ports:
+// nmap does not run, which makes scans much faster when service information is not
+// needed. The result is parsed with ParseGreppable, so the ports have no service
+// information, and options of the nmap stage cannot be used.
+func WithGreppable() Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "-g")
+		s.greppable = true
+	}
+}
+
+// WithFastParse parses the open ports RustScan reports, such as the lines of
+// WithGreppable, with parsers that scan the bytes of each line rather than going
+// through regular expressions and strings.Split. The results are the same, with far
+// fewer allocations, which matters on huge greppable outputs.
+func WithFastParse() Option {
+	return func(s *Scanner) {
+		s.fastParse = true
+	}
+}
+
+// ScriptMode selects the scripts RustScan runs on the open ports it finds.
+type ScriptMode string
+
+// Enumerates the script modes of WithScripts.
+const (
+	// ScriptsNone runs no script, not even nmap.
+	ScriptsNone ScriptMode = "none"
+	// ScriptsDefault runs nmap, the default.
+	ScriptsDefault ScriptMode = "default"
+	// ScriptsCustom runs the scripts of the RustScan scripts file, rustscan_scripts.toml.
+	ScriptsCustom ScriptMode = "custom"
+)
+
+// WithScripts selects the scripts RustScan runs on the open ports it finds (--scripts).
+// With ScriptsNone, nmap does not run: the result holds the open ports RustScan
+// reported, without service information, and options of the nmap stage cannot be used.
+// With ScriptsCustom, the result is parsed from nmap's XML output if the scripts print
+// one, and holds the open ports RustScan reported otherwise.
+func WithScripts(mode ScriptMode) Option {
+	return func(s *Scanner) {
+		switch mode {
+		case ScriptsNone, ScriptsDefault, ScriptsCustom:
+		default:
+			s.errs = append(s.errs, fmt.Errorf("invalid script mode %q", mode))
+			return
+		}
+
+		s.args = append(s.args, "--scripts", string(mode))
+		s.scriptMode = mode
+	}
+}
+
+// runsNmap reports whether RustScan runs nmap after its port scan, in which case the
+// result is parsed from nmap's XML output.
+func (s *Scanner) runsNmap() bool {
+	return !s.greppable && s.scriptMode != ScriptsNone
+}
+
+// WithMinimalMemory lowers the memory a scan needs further by not keeping nmap's raw XML
+// output, which Run otherwise keeps along with the result it decodes as RustScan prints
+// the output. ToFile and ToReader then have nothing to write, and a scan that is stopped
+// after WithKillGracePeriod only recovers the open ports RustScan reported.
+func WithMinimalMemory() Option {
+	return func(s *Scanner) {
+		s.minimalMemory = true
+	}
+}
+
+// WithKillGracePeriod changes how a scan stops when its context is done. Instead of
+// killing RustScan right away, it is asked to exit (SIGTERM) and given up to grace to
+// do so before being killed, which lets it flush its output. Run then returns what the
+// scan found along with ErrScanTimeout: nmap's XML output if it was written completely,
+// the open ports RustScan reported otherwise. Processes are killed right away on
+// platforms without SIGTERM.
+func WithKillGracePeriod(grace time.Duration) Option {
+	return func(s *Scanner) {
+		s.killGrace = grace
+	}
+}
+
+// WithAllowedWindow only allows scans to start between start and end: outside of the
+// window, Run returns ErrOutsideScanWindow without starting RustScan. Scans that are
+// running when the window ends are not stopped, combine it with WithContext for that.
+// Bounds without a date, such as the ones returned by time.Parse("15:04", "22:00"), make
+// a window that repeats every day in their location, and a daily window ending before
+// it starts spans midnight.
+func WithAllowedWindow(start, end time.Time) Option {
+	return func(s *Scanner) {
+		window := &scanWindow{start: start, end: end}
+		if !window.daily() && !start.Before(end) {
+			s.errs = append(s.errs, fmt.Errorf("invalid scan window: %s is not before %s", start, end))
+			return
+		}
+
+		s.window = window
+	}
+}
+
+// WithStrictPrivilegeCheck makes Run return ErrPrivilegesRequired before starting
+// RustScan when the nmap stage uses a scan type that needs root privileges, such as a
+// SYN scan (-sS), while the process does not run as root. Without it, nmap fails on its
+// own once RustScan is done, with an error that is easy to miss among the warnings.
+// The check is only done on Unix systems.
+func WithStrictPrivilegeCheck() Option {
+	return func(s *Scanner) {
+		s.strictPrivileges = true
+	}
+}
+
+// privilegedFlags are the nmap flags that need root privileges to send raw packets.
+var privilegedFlags = []string{"-sS", "-sU", "-sY", "-sA", "-sW", "-sM", "-sN", "-sF", "-sX", "-sO", "-O"}
+
+// checkPrivileges returns ErrPrivilegesRequired when the nmap arguments need root
+// privileges the process does not have.
+func (s *Scanner) checkPrivileges() error {
+	if isPrivileged() {
+		return nil
+	}
+
+	for _, arg := range s.allNmapArgs() {
+		if containsString(privilegedFlags, arg) {
+			return fmt.Errorf("%w: nmap %s", ErrPrivilegesRequired, arg)
+		}
+	}
+
+	return nil
+}
+
+// allNmapArgs returns the arguments of the nmap stage, including the ones given after
+// "--" with WithCustomArguments.
+func (s *Scanner) allNmapArgs() []string {
+	nmapArgs := s.nmapArgs
+	for idx, arg := range s.args {
+		if arg == "--" {
+			return append(nmapArgs[:len(nmapArgs):len(nmapArgs)], s.args[idx+1:]...)
+		}
+	}
+
+	return nmapArgs
+}
+
+// WithGeoIP sets Host.Geo on the hosts of the result to the location of their first IP
+// address, as returned by the provider. The lookups run once the scan is done, before
+// the filters; a failed lookup leaves Geo unset and is reported among the warnings.
+func WithGeoIP(provider GeoIPProvider) Option {
+	return func(s *Scanner) {
+		s.geoIP = provider
+	}
+}
+
+// WithEventStreamJSON writes the events of each scan to w as newline delimited JSON, for
+// ingestion by a log pipeline or a SIEM: a scan_start event, a port_open event for each
+// open port as RustScan reports it, a host_done event for each host of the result and a
+// scan_end event, see ScanEvent. Writing the events does not stop a scan: the first write
+// error is returned among the warnings of Run.
+func WithEventStreamJSON(w io.Writer) Option {
+	return func(s *Scanner) {
+		s.eventWriter = w
+	}
+}
+
+// WithRetryBudget bounds the number of retries a scan may go through, to limit its
+// worst-case duration on lossy or hostile networks. Every line of stderr reporting a
+// retransmission or a port given up after too many of them counts as a retry. Once
+// there are more than total retries, the process is killed and Run returns
+// ErrRetryBudgetExceeded. A budget of zero, the default, puts no bound on the retries.
+func WithRetryBudget(total int) Option {
+	return func(s *Scanner) {
+		if total < 0 {
+			s.errs = append(s.errs, fmt.Errorf("invalid retry budget %d", total))
+			return
+		}
+
+		s.retryBudget = total
+	}
+}
+
+// WithMetadata attaches arbitrary key/value pairs to the scanner, which are copied
+// onto every Run it returns. It can be used to correlate results with the request
+// that started the scan, such as a request ID. Calling it several times merges the maps.
+func WithMetadata(metadata map[string]string) Option {
+	return func(s *Scanner) {
+		if s.metadata == nil {
+			s.metadata = make(map[string]string, len(metadata))
+		}
+
+		for key, value := range metadata {
+			s.metadata[key] = value
+		}
+	}
+}
+
+// WithAppendOutputFile appends every host of the scan results to the file at path,
+// one JSON object per line, instead of leaving persistence to the end of the caller's
+// workflow. Each host is written as soon as it is decoded from nmap's output, so a scan
+// that crashes midway keeps the hosts it finished. The file is created if needed and
+// never truncated, so the results of consecutive scans accumulate in it. The port and
+// host filters are applied before hosts are written, but what needs the whole result,
+// such as WithGeoIP, sorting or WithResultValidator, is not. Since hosts are written
+// before the scan ends, those of a scan that then fails are persisted too, such as one
+// rejected by WithResultValidator or one nmap reports an error for.
+func WithAppendOutputFile(path string) Option {
+	return func(s *Scanner) {
+		s.appendOutput = path
+	}
+}
+
+/*** Target specification ***/
+
+// WithTargets sets the target of a scanner.
+func WithTargets(targets ...string) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "-a")
+		s.args = append(s.args, targets...)
+	}
+}
+
+// WithForbiddenCIDRs sets ranges that must never be scanned, as CIDRs or single IPs.
+// When a scan starts, the parts of CIDR targets overlapping a forbidden range are
+// removed from them, and Run returns ErrForbiddenTarget if a target lies entirely within
+// one. Hostnames are resolved to check their addresses, and rejected if they do not
+// resolve, so that nothing is scanned without being checked; files of addresses given
+// to RustScan cannot be checked, see WithAddressesFile.
+func WithForbiddenCIDRs(cidrs ...string) Option {
+	return func(s *Scanner) {
+		for _, cidr := range cidrs {
+			network, err := parseForbiddenRange(cidr)
+			if err != nil {
+				s.errs = append(s.errs, err)
+				continue
+			}
+
+			s.forbidden = append(s.forbidden, network)
+		}
+	}
+}
+
+// WithExcludeAddresses keeps addresses out of a scan, such as a gateway or fragile
+// appliances within a CIDR target. Each address is an IP address, a CIDR or a hostname;
+// an invalid one makes Run fail. The addresses are removed from the targets of
+// RustScan, the rest of a CIDR being scanned as smaller CIDRs, and excluded from the
+// nmap stage (--exclude). The hosts having one of them are removed from the result as
+// well, which covers hostnames resolving to an excluded address.
+func WithExcludeAddresses(addrs ...string) Option {
+	return func(s *Scanner) {
+		for _, addr := range addrs {
+			if err := s.excluded.add(addr); err != nil {
+				s.errs = append(s.errs, err)
+			}
+		}
+	}
+}
+
+// WithAddressesFile makes RustScan read targets from the file at path, one address,
+// CIDR or hostname per line, which avoids passing a long list of targets on the command
+// line. It can be combined with WithTargets: RustScan then scans the targets of the file
+// along with the other ones. A file that cannot be found makes Run fail. Since its
+// content is only read by RustScan, it cannot be combined with WithForbiddenCIDRs.
+func WithAddressesFile(path string) Option {
+	return func(s *Scanner) {
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			err = fmt.Errorf("%s is a directory", path)
+		}
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("invalid addresses file: %w", err))
+			return
+		}
+
+		s.args = append(s.args, "-a", path)
+		s.addressFiles = append(s.addressFiles, path)
+	}
+}
+
+// WithHostPortPairs sets targets as host:port pairs, such as "10.0.0.1:22" or
+// "[::1]:443", instead of WithTargets and WithPorts. Hosts sharing the same set of ports
+// are scanned together, and each distinct set of ports is scanned by a separate RustScan
+// process; the results are merged into a single Run. Invalid pairs make Run fail.
+func WithHostPortPairs(pairs ...string) Option {
+	groups, err := groupHostPortPairs(pairs)
+
+	return func(s *Scanner) {
+		if err != nil {
+			s.errs = append(s.errs, err)
+			return
+		}
+
+		s.pairGroups = append(s.pairGroups, groups...)
+	}
+}
+
+// TCPFlag represents a TCP flag.
+type TCPFlag int
+
+/*** Port specification and scan order ***/
+
+// WithPorts sets the list of ports which the scanner should scan on each host (-p), use
+// WithRange for a range of ports. The ports of all the calls are merged into a single
+// sorted list without duplicates, so that "80,443" and "443,8080" become
+// "80,443,8080", and overlapping or adjacent ranges such as "80-100" and "90-110" are
+// scanned once as "80-110". The list is merged with the range of WithRange when the
+// scan starts, and scanned by several RustScan processes when there are too many for a
+// single command line. Ports that are not numbers between 1 and 65535 make Run
+// return an error matching ErrInvalidPort before RustScan is started.
+func WithPorts(ports ...string) Option {
+	portList := strings.Join(ports, ",")
+
+	return func(s *Scanner) {
+		if _, err := parsePortRanges(portList); err != nil {
+			s.errs = append(s.errs, fmt.Errorf("invalid ports: %w", err))
+			return
+		}
+
+		// Find if any port is set.
+		var place int = -1
+		for p, value := range s.args {
+			if value == "-p" {
+				place = p
+				break
+			}
+		}
+
+		// Add ports.
+		if place >= 0 {
+			s.args[place+1] = mergePortLists(s.args[place+1], portList)
+		} else {
+			s.args = append(s.args, "-p")
+			s.args = append(s.args, mergePortLists(portList))
+		}
+	}
+}
+
+// WithRange sets the range of ports which the scanner should scan on each host (-r),
+// from start to end inclusive.
+func WithRange(start, end int) Option {
+	return func(s *Scanner) {
+		if start < 1 || end > 65535 || start > end {
+			s.errs = append(s.errs, fmt.Errorf("%w range %d-%d", ErrInvalidPort, start, end))
+			return
+		}
+
+		s.args = append(s.args, "-r", fmt.Sprintf("%d-%d", start, end))
+	}
+}
+
+// WithDefaultPorts sets the ports to scan when no other option sets them, such as
+// WithPorts, WithTopPorts or WithHostPortPairs. Without ports RustScan scans all 65535 of them, which
+// is rarely what a forgotten WithPorts meant; a scanner built by shared code can use this
+// to fall back to a sensible list, such as the most common ports, while still letting
+// its callers pick their own.
+func WithDefaultPorts(spec string) Option {
+	return func(s *Scanner) {
+		ranges, err := parsePortRanges(spec)
+		if err == nil && len(ranges) == 0 {
+			err = fmt.Errorf("no ports in %q", spec)
+		}
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("invalid default ports: %w", err))
+			return
+		}
+
+		s.defaultPorts = spec
+	}
+}
+
+// WithExcludePorts sets ports which the scanner should not scan, as individual ports
+// or ranges such as "9000-9100". Like with WithPorts, the ports of all the calls are
+// merged. Run returns a warning when they exclude every port the scan would scan.
+func WithExcludePorts(ports ...string) Option {
+	return func(s *Scanner) {
+		spec := strings.Join(ports, ",")
+		if _, err := parsePortRanges(spec); err != nil {
+			s.errs = append(s.errs, fmt.Errorf("invalid excluded ports: %w", err))
+			return
+		}
+
+		s.args = append(s.args, "-e", spec)
+	}
+}
+
+// WithTopPorts scans the most common ports (--top) instead of a list of ports. The
+// ports, and how many of them are scanned, come from RustScan's configuration rather
+// than from an argument. It cannot be combined with WithPorts or WithHostPortPairs.
+func WithTopPorts() Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "--top")
 	}
+}
 
-	if scanner.ctx == nil {
-		scanner.ctx = context.Background()
+// WithbatchSize The batch size for port scanning, it increases or slows the speed of scanning.
+// Depends on the open file limit of your OS.  If you do 65535 it will do every port
+// at the same time. Although, your OS may not support this [default: 4500]
+func WithBatchSize(size int) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "-b")
+		s.args = append(s.args, fmt.Sprint(size))
 	}
+}
 
-	return scanner, nil
+// The timeout in milliseconds before a port is assumed to be closed [default: 1500]
+// This is the connect timeout of RustScan's own port scan, see WithNmapConnectTimeout
+// for the nmap stage.
+func WithTimeout(number int) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "-t")
+		s.args = append(s.args, fmt.Sprint(number))
+	}
 }
 
-// Run runs RustScan synchronously and returns the result of the scan.
-func (s *Scanner) Run(limit int) (result *Run, warnings []string, err error) {
-	var (
-		stderr bytes.Buffer
-		resume         bool
-	)
+// WithTries sets how many times RustScan tries a port before considering it closed
+// (--tries) [default: 1]. More tries find more open ports on lossy networks, at the
+// cost of time. It cannot be combined with WithConnectBehavior, which sets it too.
+func WithTries(n int) Option {
+	return func(s *Scanner) {
+		if n < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid number of tries %d", n))
+			return
+		}
 
-	args := s.args
+		s.args = append(s.args, "--tries", strconv.Itoa(n))
+	}
+}
 
-	for _, arg := range args {
-		if arg == "--resume" {
-			resume = true
-			break
+// WithConnectBehavior sets how RustScan connects to each port: every connection attempt
+// waits up to timeout, and a port is tried up to tries times before being considered
+// closed. A closed or filtered port thus costs up to timeout × tries, 3 seconds with a
+// 1 second timeout and 3 tries, which bounds how long a batch of ports takes. It sets
+// the timeout (-t, rounded to the millisecond) and the tries (--tries), so it cannot be
+// combined with WithTimeout.
+func WithConnectBehavior(timeout time.Duration, tries int) Option {
+	return func(s *Scanner) {
+		if timeout < time.Millisecond || tries < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid connect behavior: timeout %s, tries %d", timeout, tries))
+			return
 		}
+
+		s.args = append(s.args, "-t", strconv.FormatInt(timeout.Milliseconds(), 10))
+		s.args = append(s.args, "--tries", strconv.Itoa(tries))
 	}
+}
 
-	if !resume {
-		args = append(args, "--")
-		// Enable XML output
-		args = append(args, "-oX")
-		// Get XML output in stdout instead of writing it in a file
-		args = append(args, "-")
+// WithTimeoutBackoff makes the timeout grow with each attempt of a scan that is retried:
+// the first attempt uses base, and every retry multiplies the previous timeout by
+// factor, so that congested networks get more time to answer. It sets the timeout of
+// the RustScan port scan, rounded to the millisecond, and cannot be combined with
+// WithTimeout.
+func WithTimeoutBackoff(base time.Duration, factor float64) Option {
+	return func(s *Scanner) {
+		if base < time.Millisecond || factor < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid timeout backoff: base %s, factor %g", base, factor))
+			return
+		}
+
+		s.backoffBase = base
+		s.backoffFactor = factor
 	}
+}
 
-	// Prepare RustScan process
-	cmd := exec.Command(s.binaryPath, args...)
+// attemptTimeout returns the timeout in milliseconds of the given attempt, counted from
+// zero, following the schedule set by WithTimeoutBackoff.
+func (s *Scanner) attemptTimeout(attempt int) int {
+	timeout := float64(s.backoffBase / time.Millisecond)
+	for i := 0; i < attempt; i++ {
+		timeout *= s.backoffFactor
+	}
 
-	cmdStdoutPipe, _ := cmd.StdoutPipe()
+	if timeout > math.MaxInt32 {
+		return math.MaxInt32
+	}
 
-	//cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	return int(timeout)
+}
 
-	// Run RustScan process
-	err = cmd.Start()
-	if err != nil {
-		return nil, warnings, err
+// attemptArgs adds the timeout of the given attempt to the RustScan arguments, before
+// the ones of nmap, when a timeout backoff is set.
+func (s *Scanner) attemptArgs(args []string, attempt int) []string {
+	if s.backoffBase == 0 {
+		return args
 	}
-	var out_tmp string
 
-	var n int
-	// 从管道中实时获取输出并打印到终端
-	for {
-		tmp := make([]byte, 1024)
-		_, err := cmdStdoutPipe.Read(tmp)
-		out_tmp += string(tmp)
-		if strings.Contains(string(tmp), "Open ") {
-			n++
-		}
-		if err != nil {
+	end := len(args)
+	for idx, arg := range args {
+		if arg == "--" {
+			end = idx
 			break
 		}
 	}
 
-	if n > limit {
-		// Context was done before the scan was finished.
-		// The process is killed and a timeout error is returned.
-		_ = cmd.Process.Kill()
-		return nil, warnings, ErrScanCDN
-	}
-
-	// Make a goroutine to notify the select when the scan is done.
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
+	withTimeout := make([]string, 0, len(args)+2)
+	withTimeout = append(withTimeout, args[:end]...)
+	withTimeout = append(withTimeout, "-t", strconv.Itoa(s.attemptTimeout(attempt)))
 
-	// Wait for RustScan process or timeout
-	select {
-	case <-s.ctx.Done():
+	return append(withTimeout, args[end:]...)
+}
 
-		// Context was done before the scan was finished.
-		// The process is killed and a timeout error is returned.
-		_ = cmd.Process.Kill()
-		return nil, warnings, ErrScanTimeout
-	case <-done:
+// defaultBatchSize is the batch size of RustScan when none is set.
+const defaultBatchSize = 4500
 
-		// Process RustScan stderr output containing none-critical errors and warnings
-		// Everyone needs to check whether one or some of these warnings is a hard issue in their use case
-		if stderr.Len() > 0 {
-			warnings = strings.Split(strings.Trim(stderr.String(), "\n"), "\n")
+// WithAutoRetryMalloc retries a scan that fails with ErrMallocFailed, which happens
+// when RustScan runs out of memory on large target networks, with half the batch size
+// each time, until the scan succeeds or the batch size reaches minBatch. The batch size
+// starts from the one set with WithBatchSize, or RustScan's default of 4500.
+func WithAutoRetryMalloc(minBatch int) Option {
+	return func(s *Scanner) {
+		if minBatch < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid minimum batch size %d", minBatch))
+			return
 		}
 
-		// Check for warnings that will inevitably lead to parsing errors, hence, have priority.
-		if err := analyzeWarnings(warnings); err != nil {
-			return nil, warnings, err
+		s.mallocMinBatch = minBatch
+	}
+}
+
+// runAttempts runs a RustScan process with the given arguments, and retries it with a
+// smaller batch size when it fails with ErrMallocFailed and WithAutoRetryMalloc is set.
+func (s *Scanner) runAttempts(ctx context.Context, args []string, limit int, scan *scanState, progress func(float32)) (result *Run, warnings []string, err error) {
+	batch := defaultBatchSize
+	if values, _ := extractFlag(rustScanArgs(args), "-b"); len(values) > 0 {
+		if size, convErr := strconv.Atoi(values[len(values)-1]); convErr == nil {
+			batch = size
 		}
+	}
 
-		// Parse RustScan xml output. Usually RustScan always returns valid XML, even if there is a scan error.
-		// Potentially available warnings are returned too, but probably not the reason for a broken XML.
+	for attempt := 0; ; attempt++ {
+		var runWarnings []string
+		result, runWarnings, err = s.run(ctx, s.attemptArgs(args, attempt), limit, scan, progress)
+		warnings = append(warnings, runWarnings...)
 
-		var out []byte
-		rustscan_info := strings.Split(out_tmp, "[~]")
-		for _, info := range rustscan_info {
-			if strings.Contains(info, "<?xml ") {
-				out = []byte(info[1:])
-			} else if strings.Contains(info, "Looks like I didn't find any open ports") {
-				//todo 扫描结果中没有扫出开放端口时，这里直接构造了一个 nmap 扫描结果的 xml 格式字符串，毕竟不关心关闭的端口，输出不对无关紧要,
-				out = Structure()
-			}
+		if !errors.Is(err, ErrMallocFailed) || s.mallocMinBatch == 0 || batch <= s.mallocMinBatch {
+			return result, warnings, err
 		}
 
-		result, err := Parse(out)
-		if err != nil {
-			warnings = append(warnings, err.Error()) // Append parsing error to warnings for those who are interested.
-			return nil, warnings, ErrParseOutput
+		next := batch / 2
+		if next < s.mallocMinBatch {
+			next = s.mallocMinBatch
 		}
 
-		// Critical scan errors are reflected in the XML.
-		if result != nil && len(result.Stats.Finished.ErrorMsg) > 0 {
-			switch {
-			case strings.Contains(result.Stats.Finished.ErrorMsg, "Error resolving name"):
-				return result, warnings, ErrResolveName
-			// TODO: Add cases for other known errors we might want to guard.
-			default:
-				return result, warnings, fmt.Errorf(result.Stats.Finished.ErrorMsg)
-			}
-		}
+		warnings = append(warnings, fmt.Sprintf("malloc failed with a batch size of %d, retrying with %d", batch, next))
+		batch = next
+		args = withBatchSize(args, batch)
+	}
+}
 
-		// Call filters if they are set.
-		if s.portFilter != nil {
-			result = choosePorts(result, s.portFilter)
-		}
-		if s.hostFilter != nil {
-			result = chooseHosts(result, s.hostFilter)
+// rustScanArgs returns the arguments of RustScan itself, the ones before "--".
+func rustScanArgs(args []string) []string {
+	for idx, arg := range args {
+		if arg == "--" {
+			return args[:idx]
 		}
-
-		// Return result, optional warnings but no error
-		return result, warnings, nil
 	}
+
+	return args
 }
 
-// Wait waits for the cmd to finish and returns error.
-func (s *Scanner) Wait() error {
-	return s.cmd.Wait()
+// withBatchSize replaces the batch size of the RustScan arguments.
+func withBatchSize(args []string, batch int) []string {
+	own := rustScanArgs(args)
+	_, rest := extractFlag(own, "-b")
+
+	updated := make([]string, 0, len(args)+2)
+	updated = append(updated, rest...)
+	updated = append(updated, "-b", strconv.Itoa(batch))
+
+	return append(updated, args[len(own):]...)
 }
 
-// GetStdout returns stdout variable for scanner.
-func (s *Scanner) GetStdout() bufio.Scanner {
-	return s.stdout
+// The order of scanning to be performed. The "serial" option will scan ports in
+//  ascending order while the "random" option will scan ports randomly [default:
+//  serial]  [possible values: Serial, Random]
+func WithScanOrder(order string) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "--scan-order")
+		s.args = append(s.args, order)
+	}
 }
 
-// GetStderr returns stderr variable for scanner.
-func (s *Scanner) GetStderr() bufio.Scanner {
-	return s.stderr
+// WithCustomPortOrder lets a function decide the order in which ports are scanned.
+// The function receives every port requested with WithPorts, ranges expanded, and
+// returns them in the desired order; the result is passed to RustScan as an explicit
+// -p list. RustScan still scans a batch of ports at once, so combine it with
+// WithScanOrder("serial") and a small batch size when the order matters closely.
+func WithCustomPortOrder(order func(ports []int) []int) Option {
+	return func(s *Scanner) {
+		s.portOrder = order
+	}
 }
 
-// AddOptions sets more scan options after the scan is created.
-func (s *Scanner) AddOptions(options ...Option) {
-	for _, option := range options {
-		option(s)
+// WithRiskTierOrder scans the most sensitive ports first, following the built-in risk
+// tiers returned by PortRiskTier: remote administration, then databases, then web
+// servers, then every other port. It also sets Port.RiskTier on the ports of the result,
+// before the filters run. Like WithCustomPortOrder, which it replaces, it orders the
+// ports set with WithPorts.
+func WithRiskTierOrder() Option {
+	return func(s *Scanner) {
+		s.portOrder = orderByRiskTier
+		s.riskTiers = true
 	}
 }
 
-func chooseHosts(result *Run, filter func(Host) bool) *Run {
-	var filteredHosts []Host
+// WithUlimit  Automatically ups the ULIMIT with the value you provided
+func WithUlimit(ulimit int) Option {
+	return func(s *Scanner) {
+		s.args = append(s.args, "-u")
+		s.args = append(s.args, fmt.Sprint(ulimit))
+	}
+}
 
-	for _, host := range result.Hosts {
-		if filter(host) {
-			filteredHosts = append(filteredHosts, host)
+// WithMaxPortsPerSecond sizes the RustScan port scan for roughly n ports per second.
+// RustScan has no rate option: this sets the batch size, the number of ports probed at
+// once, to n (at most 65535) and keeps a one second timeout. The batch size bounds how
+// many connections are in flight, it does not enforce a rate. Only ports that never
+// answer hold their slot for the whole timeout; closed ports answer at once and free
+// theirs, so the real rate is usually well above n. It sets -b and -t, so it should not
+// be combined with WithBatchSize or WithTimeout. The rate has to be positive.
+func WithMaxPortsPerSecond(n int) Option {
+	return func(s *Scanner) {
+		if n < 1 {
+			s.errs = append(s.errs, fmt.Errorf("invalid rate of %d ports per second", n))
+			return
 		}
+
+		batch, timeout := throttle(n)
+		WithBatchSize(batch)(s)
+		WithTimeout(timeout)(s)
 	}
+}
 
-	result.Hosts = filteredHosts
+// throttle computes the batch size and timeout in milliseconds for a rate of n ports per
+// second, with n positive. The batch size bounds the concurrency of the scan, not its
+// rate, see WithMaxPortsPerSecond. A batch holds at most 65535 ports; faster rates keep
+// the largest batch and the default timeout rather than shortening it, which would only
+// make slow and filtered ports drop out of the results.
+func throttle(n int) (batch, timeout int) {
+	const maxBatch = 65535
 
-	return result
+	if n > maxBatch {
+		n = maxBatch
+	}
+
+	return n, 1000
 }
 
-func choosePorts(result *Run, filter func(Port) bool) *Run {
-	for idx := range result.Hosts {
-		var filteredPorts []Port
+// scanDepth is the effort of both stages of a scan at one level of WithScanDepth.
+type scanDepth struct {
+	batch, timeout int
+	nmapArgs       []string
+}
 
-		for _, port := range result.Hosts[idx].Ports {
-			if filter(port) {
-				filteredPorts = append(filteredPorts, port)
-			}
+// scanDepths are the levels of WithScanDepth, from 1 to 5.
+var scanDepths = []scanDepth{
+	{batch: 4500, timeout: 500},
+	{batch: 4500, timeout: 1000, nmapArgs: []string{"-sV", "--version-intensity", "2"}},
+	{batch: 4500, timeout: 1500, nmapArgs: []string{"-sV", "--version-intensity", "5"}},
+	{batch: 2500, timeout: 2000, nmapArgs: []string{"-sV", "--version-intensity", "7", "-sC"}},
+	{batch: 1000, timeout: 3000, nmapArgs: []string{"-sV", "--version-intensity", "9", "--script", "default,safe"}},
+}
+
+// WithScanDepth trades the speed of a scan for the depth of its results with a single
+// level, from 1 (fastest) to 5 (most thorough). It sets the batch size and timeout of
+// the RustScan port scan, and the service detection and scripts of nmap:
+//
+//	level  batch  timeout  nmap
+//	1      4500   500ms    no service detection
+//	2      4500   1000ms   -sV --version-intensity 2
+//	3      4500   1500ms   -sV --version-intensity 5
+//	4      2500   2000ms   -sV --version-intensity 7 -sC
+//	5      1000   3000ms   -sV --version-intensity 9 --script default,safe
+//
+// Level 3 keeps RustScan's defaults. Larger batches and shorter timeouts finish sooner
+// but miss slow ports, so the deeper levels lower both. It sets -b and -t, so it cannot
+// be combined with WithBatchSize or WithTimeout.
+func WithScanDepth(level int) Option {
+	return func(s *Scanner) {
+		if level < 1 || level > len(scanDepths) {
+			s.errs = append(s.errs, fmt.Errorf("invalid scan depth %d, must be between 1 and %d", level, len(scanDepths)))
+			return
 		}
 
-		result.Hosts[idx].Ports = filteredPorts
+		depth := scanDepths[level-1]
+		WithBatchSize(depth.batch)(s)
+		WithTimeout(depth.timeout)(s)
+		s.nmapArgs = append(s.nmapArgs, depth.nmapArgs...)
 	}
-
-	return result
 }
 
-func analyzeWarnings(warnings []string) error {
-	// Check for warnings that will inevitably lead to parsing errors, hence, have priority.
-	for _, warning := range warnings {
-		switch {
-		case strings.Contains(warning, "Malloc Failed!"):
-			return ErrMallocFailed
-		// TODO: Add cases for other known errors we might want to guard.
-		default:
-		}
+/*** Nmap stage ***/
+
+// WithNmapArguments sets arguments for the nmap stage RustScan runs after its port
+// scan, which are passed after "--" along with the ones of the other options of this
+// stage. nmap's XML output is already written to stdout for Run to parse, so the
+// arguments cannot set -oX or -oA.
+func WithNmapArguments(args ...string) Option {
+	return func(s *Scanner) {
+		s.nmapArgs = append(s.nmapArgs, args...)
 	}
-	return nil
 }
 
-// WithContext adds a context to a scanner, to make it cancellable and able to timeout.
-func WithContext(ctx context.Context) Option {
+// WithServiceInfo makes nmap probe the open ports to detect the service and version
+// listening on them (-sV), which Port.Service holds. It only has an effect when nmap
+// runs, so it cannot be combined with WithGreppable or WithScripts(ScriptsNone).
+func WithServiceInfo() Option {
 	return func(s *Scanner) {
-		s.ctx = ctx
+		s.nmapArgs = append(s.nmapArgs, "-sV")
 	}
 }
 
-// WithBinaryPath sets the RustScan binary path for a scanner.
-func WithBinaryPath(binaryPath string) Option {
+// maxVerbosity is the highest verbosity WithVerbosity sets.
+const maxVerbosity = 4
+
+// WithVerbosity sets the verbosity of nmap, level 1 to 4 passing -v to -vvvv, which
+// adds details such as the reasons of the port states and the progress of its tasks to
+// the XML output. Levels are clamped between 0, which adds nothing, and 4. It does not
+// change RustScan's own output, which Run parses.
+func WithVerbosity(level int) Option {
+	switch {
+	case level < 0:
+		level = 0
+	case level > maxVerbosity:
+		level = maxVerbosity
+	}
+
 	return func(s *Scanner) {
-		s.binaryPath = binaryPath
+		if level > 0 {
+			s.nmapArgs = append(s.nmapArgs, "-"+strings.Repeat("v", level))
+		}
 	}
 }
 
-// WithCustomArguments sets custom arguments to give to the RustScan binary.
-// There should be no reason to use this, unless you are using a custom build
-// of RustScan or that this repository isn't up to date with the latest options
-// of the official RustScan release.
-// You can use this as a quick way to paste an RustScan command into your go code,
-// but remember that the whole purpose of this repository is to be idiomatic,
-// provide type checking, enums for the values that can be passed, etc.
-func WithCustomArguments(args ...string) Option {
+// WithDefaultScript runs nmap's default NSE scripts (-sC) on the ports RustScan found.
+// Script output is reported on the port it ran against in Port.Scripts, while host rule
+// scripts end up in Host.HostScripts.
+func WithDefaultScript() Option {
 	return func(s *Scanner) {
-		s.args = append(s.args, args...)
+		s.nmapArgs = append(s.nmapArgs, "-sC")
 	}
 }
 
-// WithFilterPort allows to set a custom function to filter out ports that
-// don't fulfill a given condition. When the given function returns true,
-// the port is kept, otherwise it is removed from the result. Can be used
-// along with WithFilterHost.
-func WithFilterPort(portFilter func(Port) bool) Option {
+// WithNmapConnectTimeout caps how long nmap waits for a probe response during the
+// service detection stage (--max-rtt-timeout). It is distinct from WithTimeout, which
+// only applies to RustScan's port scan: a port RustScan reported open is probed by nmap
+// with its own timing, which this option bounds. nmap takes it in milliseconds, so it
+// has to be at least one millisecond.
+func WithNmapConnectTimeout(timeout time.Duration) Option {
 	return func(s *Scanner) {
-		s.portFilter = portFilter
+		if timeout < time.Millisecond {
+			s.errs = append(s.errs, fmt.Errorf("invalid nmap connect timeout %v", timeout))
+			return
+		}
+
+		s.nmapArgs = append(s.nmapArgs, "--max-rtt-timeout", fmt.Sprintf("%dms", timeout.Milliseconds()))
 	}
 }
 
-// WithFilterHost allows to set a custom function to filter out hosts that
-// don't fulfill a given condition. When the given function returns true,
-// the host is kept, otherwise it is removed from the result. Can be used
-// along with WithFilterPort.
-func WithFilterHost(hostFilter func(Host) bool) Option {
+// WithDisableArpPing disables nmap's ARP host discovery (--disable-arp-ping). On a local
+// ethernet network nmap uses ARP requests to find live hosts; this is useless when the
+// targets are behind a router, and proxy ARP on some networks makes every address look up.
+func WithDisableArpPing() Option {
 	return func(s *Scanner) {
-		s.hostFilter = hostFilter
+		s.nmapArgs = append(s.nmapArgs, "--disable-arp-ping")
 	}
 }
 
-/*** Target specification ***/
-
-// WithTargets sets the target of a scanner.
-func WithTargets(targets ...string) Option {
+// WithSCTPScan makes nmap probe the ports with an SCTP INIT scan (-sY). The SCTP ports
+// are reported in Host.Ports along with the others, with "sctp" as their Protocol.
+// This scan type requires root privileges.
+func WithSCTPScan() Option {
 	return func(s *Scanner) {
-		s.args = append(s.args, "-a")
-		s.args = append(s.args, targets...)
+		s.nmapArgs = append(s.nmapArgs, "-sY")
 	}
 }
 
-// TCPFlag represents a TCP flag.
-type TCPFlag int
-
-/*** Port specification and scan order ***/
-
-// WithPorts sets the ports which the scanner should scan on each host.
-func WithPorts(ports ...string) Option {
-	portList := strings.Join(ports, ",")
-
-	var elems string
-	if strings.Contains(portList, ",") {
-		elems = "-p"
-	} else {
-		elems = "-r"
+// WithUDPScan makes nmap probe the ports RustScan found open with a UDP scan (-sU),
+// to detect the UDP services listening on the same port numbers. The UDP ports are
+// reported in Host.Ports along with the others, with "udp" as their Protocol. nmap only
+// runs a TCP scan along with it when one is set, for instance with
+// WithNmapArguments("-sS"). This scan type requires root privileges.
+func WithUDPScan() Option {
+	return func(s *Scanner) {
+		s.nmapArgs = append(s.nmapArgs, "-sU")
 	}
+}
 
+// WithOSDetection makes nmap fingerprint the operating system of the hosts (-O). The
+// candidates are reported in Host.OS.Matches, best first, and left empty for hosts
+// nmap could not fingerprint. OS detection requires root privileges.
+func WithOSDetection() Option {
 	return func(s *Scanner) {
-		// Find if any port is set.
-		var place int = -1
-		for p, value := range s.args {
-			if value == "-p" {
-				place = p
-				break
-			}
-		}
-
-		// Add ports.
-		if place >= 0 {
-			portList = s.args[place+1] + "," + portList
-			s.args[place+1] = portList
-		} else {
-			s.args = append(s.args, elems)
-			s.args = append(s.args, portList)
-		}
+		s.nmapArgs = append(s.nmapArgs, "-O")
 	}
 }
 
-
-// WithbatchSize The batch size for port scanning, it increases or slows the speed of scanning.
-// Depends on the open file limit of your OS.  If you do 65535 it will do every port
-// at the same time. Although, your OS may not support this [default: 4500]
-func WithBatchSize(size int) Option {
+// WithForceIPv4 makes nmap use IPv4 (-4), so that dual-stack hostnames given to it are
+// resolved to their IPv4 address. It cannot be combined with WithIPv6.
+func WithForceIPv4() Option {
 	return func(s *Scanner) {
-		s.args = append(s.args, "-b")
-		s.args = append(s.args, fmt.Sprint(size))
+		s.nmapArgs = append(s.nmapArgs, "-4")
 	}
 }
 
-// The timeout in milliseconds before a port is assumed to be closed [default: 1500]
-func WithTimeout(number int) Option {
+// WithIPv6 makes nmap use IPv6 (-6), which it needs to probe IPv6 targets. RustScan
+// scans IPv6 addresses on its own, so the targets should be IPv6 addresses or hostnames
+// that RustScan resolves to one. It cannot be combined with WithForceIPv4.
+func WithIPv6() Option {
 	return func(s *Scanner) {
-		s.args = append(s.args, "-t")
-		s.args = append(s.args, fmt.Sprint(number))
+		s.nmapArgs = append(s.nmapArgs, "-6")
 	}
 }
 
-// The order of scanning to be performed. The "serial" option will scan ports in
-//  ascending order while the "random" option will scan ports randomly [default:
-//  serial]  [possible values: Serial, Random]
-func WithScanOrder(order string) Option {
+// WithScriptArguments sets arguments for the NSE scripts run by nmap (--script-args).
+// Calling it several times merges the arguments.
+func WithScriptArguments(arguments map[string]string) Option {
 	return func(s *Scanner) {
-		s.args = append(s.args, "--scan-order")
-		s.args = append(s.args, order)
+		if s.scriptArgs == nil {
+			s.scriptArgs = make(map[string]string, len(arguments))
+		}
+
+		for key, value := range arguments {
+			s.scriptArgs[key] = value
+		}
 	}
 }
 
-// WithUlimit  Automatically ups the ULIMIT with the value you provided
-func WithUlimit(ulimit int) Option {
+// WithScriptHTTPProxy routes the requests of the web NSE scripts through a proxy by
+// setting the http.proxy script argument. The URL must have an http, https, socks4 or
+// socks5 scheme and a host, otherwise Run returns an error.
+func WithScriptHTTPProxy(proxyURL string) Option {
+	u, err := url.Parse(proxyURL)
+	if err == nil {
+		switch {
+		case u.Host == "":
+			err = fmt.Errorf("proxy URL %q has no host", proxyURL)
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks4" && u.Scheme != "socks5":
+			err = fmt.Errorf("proxy URL %q has unsupported scheme %q", proxyURL, u.Scheme)
+		}
+	}
+
 	return func(s *Scanner) {
-		s.args = append(s.args, "-u")
-		s.args = append(s.args, fmt.Sprint(ulimit))
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("invalid script HTTP proxy: %w", err))
+			return
+		}
+
+		WithScriptArguments(map[string]string{"http.proxy": proxyURL})(s)
 	}
 }
 
+// formatScriptArgs joins script arguments into the --script-args syntax, sorted by
+// name. Values containing separators are quoted.
+func formatScriptArgs(arguments map[string]string) string {
+	keys := make([]string, 0, len(arguments))
+	for key := range arguments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	elems := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := arguments[key]
+		if strings.ContainsAny(value, ",={}\" \t") {
+			value = `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+		elems = append(elems, key+"="+value)
+	}
+
+	return strings.Join(elems, ",")
+}
 
 // ReturnArgs return the list of RustScan args
 func (s *Scanner) Args() []string {
 	return s.args
 }
 
-func Structure() []byte {
+// Structure builds the nmap XML output of a scan that found no open port on the given
+// targets, which RustScan does not run nmap for. Each target is reported as a host that
+// is up with its closed port 80.
+//
+// Deprecated: Run no longer uses it, a scan that found no open port returns its hosts
+// without ports instead.
+func Structure(targets ...string) []byte {
 	close_info := `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE nmaprun>
 <?xml-stylesheet href="file:///usr/local/bin/../share/nmap/nmap.xsl" type="text/xsl"?>
@@ -404,25 +2377,39 @@ func Structure() []byte {
 <scaninfo type="connect" protocol="tcp" numservices="2" services="80,443"/>
 <verbose level="0"/>
 <debugging level="0"/>
-<hosthint><status state="up" reason="unknown-response" reason_ttl="0"/>
-<address addr="rustscan_info" addrtype="ipv4"/>
-<hostnames>
-</hostnames>
-</hosthint>
-<host starttime="1638862444" endtime="1638862444"><status state="up" reason="conn-refused" reason_ttl="0"/>
-<address addr="rustscan_info" addrtype="ipv4"/>
+rustscan_hosts<runstats><finished time="1638862444" timestr="Tue Dec  7 15:34:04 2021" summary="Nmap done at Tue Dec  7 15:34:04 2021; rustscan_count IP address (rustscan_count host up) scanned in 0.25 seconds" elapsed="0.25" exit="success"/><hosts up="rustscan_count" down="0" total="rustscan_count"/>
+</runstats>
+</nmaprun>`
+
+	host_info := `<host starttime="1638862444" endtime="1638862444"><status state="up" reason="conn-refused" reason_ttl="0"/>
+<address addr="rustscan_info" addrtype="rustscan_type"/>
 <hostnames>
 </hostnames>
 <ports><port protocol="tcp" portid="80"><state state="closed" reason="conn-refused" reason_ttl="0"/><service name="http" method="table" conf="3"/></port>
 </ports>
 <times srtt="53510" rttvar="31142" to="178078"/>
 </host>
-<runstats><finished time="1638862444" timestr="Tue Dec  7 15:34:04 2021" summary="Nmap done at Tue Dec  7 15:34:04 2021; 1 IP address (1 host up) scanned in 0.25 seconds" elapsed="0.25" exit="success"/><hosts up="1" down="0" total="1"/>
-</runstats>
-</nmaprun>`
+`
+
+	var hosts strings.Builder
+	escaped := make([]string, 0, len(targets))
+	for _, target := range targets {
+		var addr bytes.Buffer
+		_ = xml.EscapeText(&addr, []byte(target))
+		escaped = append(escaped, addr.String())
+
+		addrType := "ipv4"
+		if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+			addrType = "ipv6"
+		}
+
+		host := strings.ReplaceAll(host_info, "rustscan_info", addr.String())
+		hosts.WriteString(strings.ReplaceAll(host, "rustscan_type", addrType))
+	}
 
-	close_info = strings.ReplaceAll(close_info, "rustscan_info", "www.baidu.com")
+	close_info = strings.ReplaceAll(close_info, "rustscan_hosts", hosts.String())
+	close_info = strings.ReplaceAll(close_info, "rustscan_count", strconv.Itoa(len(targets)))
+	close_info = strings.ReplaceAll(close_info, "rustscan_info", strings.Join(escaped, " "))
 
 	return []byte(close_info)
 }
-
@@ -0,0 +1,1751 @@
+package RustScan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The tests run the test binary itself as a fake RustScan, which prints what RustScan
+// and nmap would for the addresses (-a) and ports (-p or -r) it is given, or only the
+// open ports of each host in greppable mode (-g), without colors in accessible mode
+// (--accessible), and with the progress of nmap's tasks with --stats-every. The fake is
+// set up through environment variables:
+//
+//	FAKE_RUSTSCAN       enables the fake when set
+//	FAKE_OUTPUT_FILE    file printed on stdout instead of the generated output
+//	FAKE_STDOUT         lines printed before the open ports
+//	FAKE_STDERR         lines printed on stderr
+//	FAKE_WAIT_FILE      file to wait for after the first host of the XML output
+//	FAKE_EXIT_EARLY     exit after the open ports, without running nmap
+//	FAKE_PRINT_PRIORITY print the scheduling priority of the process on stderr
+//	FAKE_ARGS_FILE      file the arguments of every run are appended to, one line each
+//	FAKE_MALLOC_ABOVE   fail like RustScan out of memory with a larger batch size (-b),
+//	                    4500 when not set
+//	FAKE_FAIL_HOST      fail when given this address (-a)
+//	FAKE_TERM_FILE      file created on SIGTERM, which is then ignored
+//	FAKE_CHILD_PID_FILE start a child process sharing stdout, like nmap, and write its
+//	                    PID to this file
+func TestMain(m *testing.M) {
+	if os.Getenv("FAKE_RUSTSCAN") != "" {
+		fakeRustScan(os.Args[1:])
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func fakeRustScan(args []string) {
+	if path := os.Getenv("FAKE_TERM_FILE"); path != "" {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		go func() {
+			<-signals
+			_ = ioutil.WriteFile(path, nil, 0666)
+		}()
+	}
+
+	if path := os.Getenv("FAKE_ARGS_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err == nil {
+			fmt.Fprintln(file, strings.Join(args, " "))
+			file.Close()
+		}
+	}
+
+	if path := os.Getenv("FAKE_OUTPUT_FILE"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(content)
+		return
+	}
+
+	value := func(flag string) string {
+		for idx := 0; idx+1 < len(args); idx++ {
+			if args[idx] == flag {
+				return args[idx+1]
+			}
+		}
+		return ""
+	}
+
+	if limit := os.Getenv("FAKE_MALLOC_ABOVE"); limit != "" {
+		batch, err := strconv.Atoi(value("-b"))
+		if err != nil {
+			batch = defaultBatchSize
+		}
+		if max, _ := strconv.Atoi(limit); batch > max {
+			fmt.Fprintln(os.Stderr, "Malloc Failed!")
+			os.Exit(1)
+		}
+	}
+
+	if host := os.Getenv("FAKE_FAIL_HOST"); host != "" && value("-a") == host {
+		fmt.Fprintf(os.Stderr, "Error: unable to scan %s\n", host)
+		os.Exit(1)
+	}
+
+	hosts := strings.Split(value("-a"), ",")
+	spec := value("-p")
+	if spec == "" {
+		spec = value("-r")
+	}
+	if spec == "" {
+		spec = "80"
+	}
+
+	var ports []string
+	for _, r := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		ports = append(ports, bounds[0])
+		if len(bounds) == 2 {
+			ports = append(ports, bounds[1])
+		}
+	}
+
+	if path := os.Getenv("FAKE_CHILD_PID_FILE"); path != "" {
+		child := exec.Command("sleep", "30")
+		child.Stdout = os.Stdout
+		if err := child.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		_ = ioutil.WriteFile(path, []byte(strconv.Itoa(child.Process.Pid)), 0666)
+	}
+
+	if stdout := os.Getenv("FAKE_STDOUT"); stdout != "" {
+		fmt.Println(stdout)
+	}
+	if stderr := os.Getenv("FAKE_STDERR"); stderr != "" {
+		fmt.Fprintln(os.Stderr, stderr)
+	}
+	if os.Getenv("FAKE_PRINT_PRIORITY") != "" {
+		fmt.Fprintf(os.Stderr, "priority %d\n", processPriority())
+	}
+
+	if containsString(args, "-g") {
+		for _, host := range hosts {
+			fmt.Printf("%s -> [%s]\n", host, strings.Join(ports, ","))
+		}
+		return
+	}
+
+	// Accessible mode leaves out the colors and the "[~]" prefixes.
+	openFormat, prefix := "Open \x1b[35m%s:%s\x1b[0m\n", "[~] "
+	if containsString(args, "--accessible") {
+		openFormat, prefix = "Open %s:%s\n", ""
+	}
+
+	for _, host := range hosts {
+		for _, port := range ports {
+			fmt.Printf(openFormat, host, port)
+		}
+	}
+
+	// Without scripts, RustScan does not run nmap.
+	if os.Getenv("FAKE_EXIT_EARLY") != "" || value("--scripts") == string(ScriptsNone) {
+		return
+	}
+
+	fmt.Println(prefix + "Starting Script(s)")
+	fmt.Println(prefix + "Starting Nmap 7.92 ( https://nmap.org ) at 2021-12-07 15:34 CST")
+	fmt.Println(prefix + `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Println(`<nmaprun scanner="nmap" args="nmap -oX -" start="1638862444" version="7.92" xmloutputversion="1.05">`)
+
+	// nmap reports the progress of its tasks in turn with --stats-every.
+	if containsString(args, "--stats-every") {
+		for _, percent := range []string{"50.00", "20.00", "80.00"} {
+			fmt.Printf(`<taskprogress task="Service scan" time="1638862444" percent="%s" remaining="5" etc="1638862449"/>`+"\n", percent)
+		}
+	}
+
+	for idx, host := range hosts {
+		fmt.Printf(`<host starttime="1638862444" endtime="1638862444"><status state="up" reason="syn-ack" reason_ttl="0"/><address addr="%s" addrtype="ipv4"/><ports>`+"\n", host)
+		for _, port := range ports {
+			fmt.Printf(`<port protocol="tcp" portid="%s"><state state="open" reason="syn-ack" reason_ttl="0"/><service name="svc%s" method="table" conf="3"/></port>`+"\n", port, port)
+		}
+		fmt.Println(`</ports></host>`)
+
+		if wait := os.Getenv("FAKE_WAIT_FILE"); idx == 0 && wait != "" {
+			for {
+				if _, err := os.Stat(wait); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	fmt.Printf(`<runstats><finished time="1638862445" timestr="x" elapsed="0.25" exit="success"/><hosts up="%d" down="0" total="%d"/></runstats></nmaprun>`+"\n", len(hosts), len(hosts))
+}
+
+// setFakeEnv sets up the fake RustScan with the given variables, and returns the
+// function restoring the environment.
+func setFakeEnv(t *testing.T, vars map[string]string) func() {
+	t.Helper()
+
+	vars["FAKE_RUSTSCAN"] = "1"
+	for key, value := range vars {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for key := range vars {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
+
+// newFakeScanner creates a scanner running the fake RustScan.
+func newFakeScanner(t *testing.T, options ...Option) *Scanner {
+	t.Helper()
+
+	scanner, err := NewScanner(append([]Option{WithBinaryPath(os.Args[0])}, options...)...)
+	if err != nil {
+		t.Fatalf("unable to create scanner: %v", err)
+	}
+
+	return scanner
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "rustscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestRunFake(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80"))
+	result, warnings, err := scanner.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v (warnings %v)", err, warnings)
+	}
+
+	if len(result.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(result.Hosts))
+	}
+	for _, host := range result.Hosts {
+		if len(host.Ports) != 2 {
+			t.Errorf("expected 2 ports on %v, got %d", host.Addresses, len(host.Ports))
+		}
+	}
+}
+
+func TestWithAppendOutputFileIncremental(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "hosts.jsonl")
+	release := filepath.Join(dir, "release")
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": release})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"), WithAppendOutputFile(path))
+	done := scanner.RunAsync()
+
+	// The first host is written while the fake still holds back the second one.
+	deadline := time.Now().Add(10 * time.Second)
+	for countLines(t, path) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("first host was not written while the scan ran")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("scan finished before the first host was written")
+	default:
+	}
+
+	if lines := countLines(t, path); lines != 1 {
+		t.Fatalf("expected 1 host written before the scan finished, got %d", lines)
+	}
+
+	if err := ioutil.WriteFile(release, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	result := <-done
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+
+	if lines := countLines(t, path); lines != 2 {
+		t.Fatalf("expected 2 hosts written, got %d", lines)
+	}
+}
+
+func TestWithAppendOutputFileRejected(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer setFakeEnv(t, map[string]string{})()
+
+	// Hosts are written as they are decoded, so a run the validator rejects afterwards
+	// is already in the file.
+	path := filepath.Join(dir, "hosts.jsonl")
+	errRejected := errors.New("rejected")
+	validator := func(*Run) error { return errRejected }
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"),
+		WithAppendOutputFile(path), WithResultValidator(validator))
+	if _, _, err := scanner.Run(); !errors.Is(err, errRejected) {
+		t.Fatalf("expected %v, got %v", errRejected, err)
+	}
+
+	if lines := countLines(t, path); lines != 2 {
+		t.Fatalf("expected 2 hosts written, got %d", lines)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(content), "\n")
+}
+
+func TestRunUlimitWarning(t *testing.T) {
+	warning := "\x1b[31m[!]\x1b[0m Your file limit is very small, which negatively impacts RustScan's speed. Use the Docker image, or up the Ulimit with '--ulimit 5000'."
+	defer setFakeEnv(t, map[string]string{"FAKE_STDOUT": warning})()
+
+	result, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Hosts) != 1 {
+		t.Errorf("expected 1 host, got %d", len(result.Hosts))
+	}
+	if result.UlimitWarning == nil || result.UlimitWarning.Suggested != 5000 {
+		t.Errorf("expected a warning suggesting 5000, got %+v", result.UlimitWarning)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "file limit is very small") {
+		t.Errorf("expected the warning among the warnings, got %v", warnings)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		rate           int
+		batch, timeout int
+	}{
+		{1, 1, 1000},
+		{500, 500, 1000},
+		{65535, 65535, 1000},
+		{131070, 65535, 1000},
+		{100000000, 65535, 1000},
+	}
+
+	for _, test := range tests {
+		batch, timeout := throttle(test.rate)
+		if batch != test.batch || timeout != test.timeout {
+			t.Errorf("%d ports per second: expected batch %d and timeout %d, got %d and %d", test.rate, test.batch, test.timeout, batch, timeout)
+		}
+	}
+}
+
+func TestWithMaxPortsPerSecond(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithMaxPortsPerSecond(500))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"-a", "10.0.0.1", "-b", "500", "-t", "1000"}; !reflect.DeepEqual(scanner.Args(), want) {
+		t.Errorf("expected %v, got %v", want, scanner.Args())
+	}
+
+	for _, rate := range []int{0, -10} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithMaxPortsPerSecond(rate)); err == nil {
+			t.Errorf("%d ports per second: expected an error", rate)
+		}
+	}
+}
+
+func TestWithNmapConnectTimeout(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithNmapConnectTimeout(1500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := [][]string{{"-a", "10.0.0.1", "--", "--max-rtt-timeout", "1500ms", "-oX", "-"}}
+	if !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	for _, timeout := range []time.Duration{0, -time.Second, 500 * time.Microsecond} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithNmapConnectTimeout(timeout)); err == nil {
+			t.Errorf("%v: expected an error", timeout)
+		}
+	}
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	retries := strings.Join([]string{
+		"Increasing send delay for 10.0.0.1 from 0 to 5 due to 11 out of 23 dropped probes",
+		"Warning: 10.0.0.1 giving up on port because retransmission cap hit (10).",
+		"Retrying 10.0.0.1",
+	}, "\n")
+	defer setFakeEnv(t, map[string]string{"FAKE_STDERR": retries})()
+
+	tests := []struct {
+		budget int
+		err    error
+	}{
+		{0, nil},
+		{3, nil},
+		{2, ErrRetryBudgetExceeded},
+	}
+
+	for _, test := range tests {
+		_, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithRetryBudget(test.budget)).Run()
+		if !errors.Is(err, test.err) {
+			t.Errorf("budget %d: expected %v, got %v", test.budget, test.err, err)
+		}
+	}
+
+	if _, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithRetryBudget(-1)); err == nil {
+		t.Error("expected an error for a negative budget")
+	}
+}
+
+func TestRunWithProgress(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	tests := []struct {
+		name    string
+		options []Option
+		err     error
+	}{
+		{"success", nil, nil},
+		{"CDN", []Option{WithCDNPortLimit(1)}, ErrScanCDN},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithTargets("10.0.0.1"), WithPorts("22,80")}, test.options...)
+		progress := make(chan float32, 100)
+
+		_, _, err := newFakeScanner(t, options...).RunWithProgress(progress)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		// The channel is closed even when the scan fails, ranging over it ends.
+		var last float32
+		for value := range progress {
+			last = value
+		}
+		if test.err == nil && last != 1 {
+			t.Errorf("%s: expected a final progress of 1, got %v", test.name, last)
+		}
+	}
+}
+
+func TestRunWithProgressValues(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	progress := make(chan float32, 100)
+	if _, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22")).RunWithProgress(progress); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var values []float32
+	for value := range progress {
+		values = append(values, value)
+	}
+
+	// The phases, then the nmap tasks, which never make the progress go back.
+	want := []float32{0, 0.5, 0.6, 0.8, 0.92, 1}
+	if len(values) != len(want) {
+		t.Fatalf("expected progress %v, got %v", want, values)
+	}
+	for idx := range want {
+		if math.Abs(float64(values[idx]-want[idx])) > 1e-6 {
+			t.Errorf("expected progress %v, got %v", want, values)
+			break
+		}
+	}
+}
+
+func TestRunWithProgressNilChannel(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22")).RunWithProgress(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Hosts) != 1 {
+		t.Errorf("expected 1 host, got %d", len(result.Hosts))
+	}
+}
+
+func TestRunAsync(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The result is delivered on the channel, which is then closed.
+	done := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80")).RunAsync()
+	result := <-done
+	if result.Err != nil || result.Run == nil || countPorts(result.Run) != 2 {
+		t.Errorf("expected the 2 open ports, got %+v", result)
+	}
+	if _, ok := <-done; ok {
+		t.Error("expected the channel to be closed")
+	}
+
+	// Cancelling the context stops a scan that would never end.
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": filepath.Join(dir, "never")})()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"), WithContext(ctx)).RunAsync()
+	cancel()
+
+	select {
+	case result := <-done:
+		if !errors.Is(result.Err, ErrScanTimeout) {
+			t.Errorf("expected ErrScanTimeout, got %v", result.Err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the cancelled scan did not stop")
+	}
+}
+
+func TestWaitBeforeRun(t *testing.T) {
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"))
+	if err := scanner.Wait(); !errors.Is(err, ErrNoActiveScan) {
+		t.Errorf("expected ErrNoActiveScan, got %v", err)
+	}
+}
+
+func TestWaitAfterRun(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_FAIL_HOST": "10.0.0.2"})()
+
+	// Wait returns right away once a scan ended, with the exit error of its process.
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"))
+	if _, _, err := scanner.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scanner.Wait(); err != nil {
+		t.Errorf("unexpected error from Wait: %v", err)
+	}
+
+	scanner = newFakeScanner(t, WithTargets("10.0.0.2"), WithPorts("22"))
+	if _, _, err := scanner.Run(); err == nil {
+		t.Fatal("expected the scan to fail")
+	}
+	var exitErr *exec.ExitError
+	if err := scanner.Wait(); !errors.As(err, &exitErr) {
+		t.Errorf("expected the exit error of the process, got %v", err)
+	}
+}
+
+func TestWaitRunAsync(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	release := filepath.Join(dir, "release")
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": release})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"))
+	done := scanner.RunAsync()
+
+	// Wait is called right away, before the process had a chance to start.
+	waited := make(chan error, 1)
+	go func() { waited <- scanner.Wait() }()
+
+	select {
+	case err := <-waited:
+		t.Fatalf("Wait returned %v before the scan ended", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := ioutil.WriteFile(release, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-waited; err != nil {
+		t.Errorf("unexpected error from Wait: %v", err)
+	}
+	if result := <-done; result.Err != nil {
+		t.Errorf("unexpected error: %v", result.Err)
+	}
+
+	if _, _, err := scanner.Run(); err != nil {
+		t.Errorf("unexpected error from a second scan: %v", err)
+	}
+}
+
+func TestRunAsyncInProgress(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	release := filepath.Join(dir, "release")
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": release})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"))
+	first := scanner.RunAsync()
+
+	if result := <-scanner.RunAsync(); !errors.Is(result.Err, ErrScanInProgress) {
+		t.Errorf("expected ErrScanInProgress, got %v", result.Err)
+	}
+
+	if err := ioutil.WriteFile(release, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-first; result.Err != nil {
+		t.Errorf("unexpected error: %v", result.Err)
+	}
+}
+
+func TestRunInProgress(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	release := filepath.Join(dir, "release")
+	argsFile := filepath.Join(dir, "args")
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": release, "FAKE_ARGS_FILE": argsFile})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22"))
+	args := append([]string(nil), scanner.Args()...)
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := scanner.Run()
+		first <- err
+	}()
+
+	// The process records its arguments once the scan started.
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(argsFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first scan did not start")
+		}
+	}
+
+	if _, _, err := scanner.Run(); !errors.Is(err, ErrScanInProgress) {
+		t.Errorf("expected ErrScanInProgress, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(release, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-first; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The scanner is reused once the scan ended, with the same arguments.
+	if _, _, err := scanner.Run(); err != nil {
+		t.Errorf("unexpected error from a second scan: %v", err)
+	}
+	runs := readArgsFile(t, argsFile)
+	if len(runs) != 2 || !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Errorf("expected the same arguments for both scans, got %v", runs)
+	}
+	if !reflect.DeepEqual(scanner.Args(), args) {
+		t.Errorf("expected the arguments of the scanner untouched, got %v instead of %v", scanner.Args(), args)
+	}
+}
+
+func TestWithStdoutMaxBytes(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_STDOUT": strings.Repeat("x", 4096)})()
+
+	tests := []struct {
+		max int64
+		err error
+	}{
+		{0, nil},
+		{1 << 20, nil},
+		{1024, ErrOutputTooLarge},
+	}
+
+	for _, test := range tests {
+		_, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithStdoutMaxBytes(test.max)).Run()
+		if !errors.Is(err, test.err) {
+			t.Errorf("cap of %d bytes: expected %v, got %v", test.max, test.err, err)
+		}
+	}
+}
+
+func TestWithMetadata(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"),
+		WithMetadata(map[string]string{"request_id": "42", "tenant": "a"}),
+		WithMetadata(map[string]string{"tenant": "b"}))
+
+	want := map[string]string{"request_id": "42", "tenant": "b"}
+	for i := 0; i < 2; i++ {
+		result, _, err := scanner.Run()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Metadata, want) {
+			t.Errorf("scan %d: expected metadata %v, got %v", i, want, result.Metadata)
+		}
+
+		// Each run has its own copy, changing it does not change the next runs.
+		result.Metadata["request_id"] = "changed"
+	}
+}
+
+// TestOptionArgs checks the command line the options give RustScan, and nmap after "--".
+func TestOptionArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		want    []string
+	}{
+		{"disable ARP ping", []Option{WithDisableArpPing()}, []string{"--", "--disable-arp-ping", "-oX", "-"}},
+		{"SCTP scan", []Option{WithSCTPScan()}, []string{"--", "-sY", "-oX", "-"}},
+		{"force IPv4", []Option{WithForceIPv4()}, []string{"--", "-4", "-oX", "-"}},
+		{"connect behavior", []Option{WithConnectBehavior(1500*time.Millisecond, 3)}, []string{"-t", "1500", "--tries", "3", "--", "-oX", "-"}},
+		{"connect behavior rounded", []Option{WithConnectBehavior(time.Second+999*time.Microsecond, 1)}, []string{"-t", "1000", "--tries", "1", "--", "-oX", "-"}},
+		{"top ports", []Option{WithTopPorts()}, []string{"--top", "--", "-oX", "-"}},
+		{"top ports and excluded ports", []Option{WithTopPorts(), WithExcludePorts("22")}, []string{"--top", "-e", "22", "--", "-oX", "-"}},
+		{"excluded ports", []Option{WithPorts("20-25"), WithExcludePorts("22,21", "9000-9002")}, []string{"-r", "20-25", "-e", "21,22", "--", "-oX", "-"}},
+		{"excluded ports of all ports", []Option{WithExcludePorts("80"), WithExcludePorts("443")}, []string{"-e", "80,443", "--", "-oX", "-"}},
+		{"scan depth 1", []Option{WithScanDepth(1)}, []string{"-b", "4500", "-t", "500", "--", "-oX", "-"}},
+		{"scan depth 4", []Option{WithScanDepth(4)}, []string{"-b", "2500", "-t", "2000", "--", "-sV", "--version-intensity", "7", "-sC", "-oX", "-"}},
+		{"scan depth 5", []Option{WithScanDepth(5)}, []string{"-b", "1000", "-t", "3000", "--", "-sV", "--version-intensity", "9", "--script", "default,safe", "-oX", "-"}},
+		{"no scripts", []Option{WithScripts(ScriptsNone)}, []string{"--scripts", "none"}},
+		{"custom scripts", []Option{WithScripts(ScriptsCustom)}, []string{"--scripts", "custom", "--", "-oX", "-"}},
+		{"default scripts", []Option{WithScripts(ScriptsDefault), WithServiceInfo()}, []string{"--scripts", "default", "--", "-sV", "-oX", "-"}},
+		{"nmap arguments", []Option{WithNmapArguments("-Pn", "-T4")}, []string{"--", "-Pn", "-T4", "-oX", "-"}},
+		{"nmap arguments after custom ones", []Option{WithCustomArguments("--", "-sC"), WithNmapArguments("-Pn")}, []string{"--", "-sC", "-Pn", "-oX", "-"}},
+		{"service info", []Option{WithServiceInfo()}, []string{"--", "-sV", "-oX", "-"}},
+		{"verbosity", []Option{WithVerbosity(2)}, []string{"--", "-vv", "-oX", "-"}},
+		{"verbosity clamped to 4", []Option{WithVerbosity(9)}, []string{"--", "-vvvv", "-oX", "-"}},
+		{"verbosity clamped to 0", []Option{WithVerbosity(-1)}, []string{"--", "-oX", "-"}},
+		{"UDP scan", []Option{WithUDPScan()}, []string{"--", "-sU", "-oX", "-"}},
+		{"OS detection", []Option{WithOSDetection()}, []string{"--", "-O", "-oX", "-"}},
+		{"tries", []Option{WithTries(3)}, []string{"--tries", "3", "--", "-oX", "-"}},
+		{"no config", []Option{WithNoConfig()}, []string{"--no-config", "--", "-oX", "-"}},
+		{"range", []Option{WithRange(1, 1000)}, []string{"-r", "1-1000", "--", "-oX", "-"}},
+		{"range containing ports", []Option{WithRange(1, 1000), WithPorts("22")}, []string{"-r", "1-1000", "--", "-oX", "-"}},
+		{"range and ports", []Option{WithPorts("2000"), WithRange(1, 3)}, []string{"-p", "1,2,3,2000", "--", "-oX", "-"}},
+		{"single port", []Option{WithPorts("80")}, []string{"-p", "80", "--", "-oX", "-"}},
+		{"ports with nmap arguments", []Option{WithPorts("80,443"), WithCustomArguments("--", "-sV")}, []string{"-p", "80,443", "--", "-sV", "-oX", "-"}},
+		{"range with nmap ports", []Option{WithRange(1, 100), WithCustomArguments("--", "-p", "443")}, []string{"-r", "1-100", "--", "-p", "443", "-oX", "-"}},
+		{"ports with nmap sequential scan", []Option{WithPorts("22"), WithCustomArguments("--", "-r", "-sV")}, []string{"-p", "22", "--", "-r", "-sV", "-oX", "-"}},
+		{"nmap network interface", []Option{WithPorts("22"), WithCustomArguments("--", "-e", "eth0")}, []string{"-p", "22", "--", "-e", "eth0", "-oX", "-"}},
+		{"excluded ports with nmap network interface", []Option{WithPorts("22,80"), WithExcludePorts("80"), WithCustomArguments("--", "-e", "eth0")}, []string{"-p", "22,80", "-e", "80", "--", "-e", "eth0", "-oX", "-"}},
+		{"default ports", []Option{WithDefaultPorts("22,80")}, []string{"-p", "22,80", "--", "-oX", "-"}},
+		{"default ports after ports", []Option{WithPorts("443"), WithDefaultPorts("22,80")}, []string{"-p", "443", "--", "-oX", "-"}},
+		{"default ports before range", []Option{WithDefaultPorts("22,80"), WithRange(1, 1000)}, []string{"-r", "1-1000", "--", "-oX", "-"}},
+		{"default ports with nmap ports", []Option{WithDefaultPorts("22,80"), WithCustomArguments("--", "-p", "443")}, []string{"-p", "22,80", "--", "-p", "443", "-oX", "-"}},
+		{"default ports with top ports", []Option{WithDefaultPorts("22,80"), WithTopPorts()}, []string{"--top", "--", "-oX", "-"}},
+		{
+			"script HTTP proxy",
+			[]Option{WithScriptHTTPProxy("http://proxy.example.com:3128"), WithScriptArguments(map[string]string{"http.useragent": "Mozilla/5.0 (X11)"})},
+			[]string{"--", "--script-args", `http.proxy=http://proxy.example.com:3128,http.useragent="Mozilla/5.0 (X11)"`, "-oX", "-"},
+		},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithBinaryPath("rustscan"), WithTargets("10.0.0.1")}, test.options...)
+		scanner, err := NewScanner(options...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		invocations, err := scanner.invocations()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		want := append([]string{"-a", "10.0.0.1"}, test.want...)
+		if len(invocations) != 1 || !reflect.DeepEqual(invocations[0], want) {
+			t.Errorf("%s: expected %v, got %v", test.name, want, invocations)
+		}
+	}
+}
+
+func TestWithConnectBehaviorInvalid(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		tries   int
+	}{
+		{0, 3},
+		{time.Microsecond, 3},
+		{-time.Second, 3},
+		{time.Second, 0},
+		{time.Second, -1},
+	}
+
+	for _, test := range tests {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithConnectBehavior(test.timeout, test.tries)); err == nil {
+			t.Errorf("%s, %d tries: expected an error", test.timeout, test.tries)
+		}
+	}
+}
+
+func TestWithScanDepthInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"level 0", []Option{WithScanDepth(0)}},
+		{"level 6", []Option{WithScanDepth(6)}},
+		{"with a batch size", []Option{WithScanDepth(2), WithBatchSize(1000)}},
+		{"with a timeout", []Option{WithTimeout(100), WithScanDepth(2)}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewScanner(append([]Option{WithBinaryPath("rustscan")}, test.options...)...); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestWithDefaultPortsInvalid(t *testing.T) {
+	for _, spec := range []string{"", "http", "0-10"} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithDefaultPorts(spec)); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestWithResultValidator(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// Every host exposing the exact same ports is what a CDN answering for all of them
+	// looks like.
+	errSamePorts := errors.New("every host has the same ports")
+	validator := func(run *Run) error {
+		if len(run.Hosts) < 2 {
+			return nil
+		}
+		for _, host := range run.Hosts[1:] {
+			if !reflect.DeepEqual(host.Ports, run.Hosts[0].Ports) {
+				return nil
+			}
+		}
+		return errSamePorts
+	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		err     error
+	}{
+		{"accepted", []string{"10.0.0.1"}, nil},
+		{"rejected", []string{"10.0.0.1", "10.0.0.2"}, errSamePorts},
+	}
+
+	for _, test := range tests {
+		scanner := newFakeScanner(t, WithTargets(test.targets...), WithPorts("22,80"), WithResultValidator(validator))
+		result, _, err := scanner.Run()
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		if result == nil || len(result.Hosts) != len(test.targets) {
+			t.Errorf("%s: expected the result along with the error, got %+v", test.name, result)
+		}
+	}
+}
+
+func TestWithAbortOnFirstOpenPort(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// The fake never gets past the first host unless the file is created, so the scan
+	// only ends when the process is killed.
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": filepath.Join(dir, "never")})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80"), WithAbortOnFirstOpenPort())
+	result, _, err := scanner.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 1 {
+		t.Fatalf("expected 1 host with 1 port, got %+v", result.Hosts)
+	}
+
+	host := result.Hosts[0]
+	if host.Addresses[0].Addr != "10.0.0.1" || host.Ports[0].ID != 22 || host.Ports[0].Status() != Open {
+		t.Errorf("expected the open port 10.0.0.1:22, got %s:%d %s", host.Addresses[0].Addr, host.Ports[0].ID, host.Ports[0].Status())
+	}
+	if host.Ports[0].Service.Name != "" {
+		t.Errorf("expected no service information, got %q", host.Ports[0].Service.Name)
+	}
+}
+
+// spreadPorts returns a list of n ports no two of which are adjacent, which the fake
+// reports open one by one.
+func spreadPorts(n int) string {
+	ports := make([]string, 0, n)
+	for port := 2; len(ports) < n; port += 2 {
+		ports = append(ports, strconv.Itoa(port))
+	}
+
+	return strings.Join(ports, ",")
+}
+
+func TestWithMaxOpenPorts(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The fake reports 200 open ports on each host.
+	spec := spreadPorts(200)
+
+	tests := []struct {
+		name    string
+		max     int
+		ports   int
+		service bool
+	}{
+		{"cap reached", 100, 100, false},
+		{"cap not reached", 500, 400, true},
+	}
+
+	for _, test := range tests {
+		result, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts(spec), WithMaxOpenPorts(test.max)).Run()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		var found []string
+		for _, host := range result.Hosts {
+			for _, port := range host.Ports {
+				found = append(found, fmt.Sprintf("%s:%d", host.Addresses[0].Addr, port.ID))
+				if (port.Service.Name != "") != test.service {
+					t.Errorf("%s: unexpected service information %+v", test.name, port.Service)
+				}
+			}
+		}
+		if len(found) != test.ports {
+			t.Errorf("%s: expected %d ports, got %d", test.name, test.ports, len(found))
+			continue
+		}
+
+		// The ports kept are the first ones RustScan reported.
+		if found[0] != "10.0.0.1:2" || found[99] != "10.0.0.1:200" {
+			t.Errorf("%s: expected the first ports to be kept, got %s to %s", test.name, found[0], found[99])
+		}
+	}
+
+	if _, err := NewScanner(WithMaxOpenPorts(0)); err == nil {
+		t.Error("expected an error for a maximum of 0")
+	}
+}
+
+func TestWithMinimalMemory(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	tests := []struct {
+		name    string
+		options []Option
+		err     error
+	}{
+		{"raw XML kept", nil, nil},
+		{"minimal memory", []Option{WithMinimalMemory()}, ErrNoRawXML},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80")}, test.options...)
+		result, _, err := newFakeScanner(t, options...).Run()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		// The result is the same, only the raw XML is missing.
+		if len(result.Hosts) != 2 || len(result.Hosts[1].Ports) != 2 {
+			t.Errorf("%s: expected 2 hosts with 2 ports, got %+v", test.name, result.Hosts)
+		}
+		if err := result.WriteXML(ioutil.Discard); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestWithAccessible(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	argsFile := filepath.Join(dir, "args")
+	defer setFakeEnv(t, map[string]string{"FAKE_ARGS_FILE": argsFile})()
+
+	// The XML output is found without the "[~]" prefix RustScan leaves out.
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80"), WithAccessible()).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 2 || result.Hosts[0].Ports[0].Service.Name != "svc22" {
+		t.Errorf("expected the nmap result of 10.0.0.1, got %+v", result.Hosts)
+	}
+	if args := readArgsFile(t, argsFile); len(args) != 1 || !containsString(args[0], "--accessible") {
+		t.Errorf("expected --accessible among the arguments, got %v", args)
+	}
+}
+
+func TestExtractXML(t *testing.T) {
+	const xml = `<?xml version="1.0"?><nmaprun></nmaprun>`
+
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"prefixed", "Open 10.0.0.1:22\n[~] Starting Nmap\n[~] " + xml + "\n", xml},
+		{"accessible", "Open 10.0.0.1:22\nStarting Nmap\n" + xml + "\n", xml},
+		{"trailing output", xml + "\n[~] Done\n", xml},
+		{"truncated", "[~] " + xml[:20], xml[:20]},
+		{"no XML", "Open 10.0.0.1:22\n", ""},
+	}
+
+	for _, test := range tests {
+		if got := string(extractXML(test.output)); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRunOutputIntact(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The output of 50 hosts spans many reads of the pipe, which must not leave any
+	// stale or missing byte in the raw XML.
+	var targets []string
+	for host := 1; host <= 50; host++ {
+		targets = append(targets, fmt.Sprintf("10.0.0.%d", host))
+	}
+
+	result, _, err := newFakeScanner(t, WithTargets(targets...), WithPorts("22,80,443")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var raw strings.Builder
+	if err := result.WriteXML(&raw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(raw.String(), "<?xml ") || !strings.HasSuffix(raw.String(), "</nmaprun>") || strings.ContainsRune(raw.String(), 0) {
+		t.Errorf("unexpected raw XML %q", raw.String())
+	}
+
+	reparsed, err := Parse([]byte(raw.String()))
+	if err != nil {
+		t.Fatalf("unable to parse the raw XML again: %v", err)
+	}
+	if len(reparsed.Hosts) != 50 || countPorts(reparsed) != 150 {
+		t.Errorf("expected 50 hosts with 3 ports, got %d hosts with %d ports", len(reparsed.Hosts), countPorts(reparsed))
+	}
+}
+
+func TestCDNPortLimitCountsLines(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	// The 200 "Open" lines span many reads of the pipe, each of which holds several of
+	// them, and some are cut across two reads: every line is counted once.
+	tests := []struct {
+		limit int
+		err   error
+	}{
+		{150, ErrScanCDN},
+		{199, ErrScanCDN},
+		{200, nil},
+	}
+
+	for _, test := range tests {
+		result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts(spreadPorts(200)), WithCDNPortLimit(test.limit)).Run()
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("limit %d: expected %v, got %v", test.limit, test.err, err)
+			continue
+		}
+		if test.err == nil && countPorts(result) != 200 {
+			t.Errorf("limit %d: expected 200 ports, got %d", test.limit, countPorts(result))
+		}
+	}
+}
+
+func TestWithCDNPortLimit(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	tests := []struct {
+		name    string
+		options []Option
+		err     error
+	}{
+		{"disabled by default", nil, nil},
+		{"disabled with 0", []Option{WithCDNPortLimit(0)}, nil},
+		{"exceeded", []Option{WithCDNPortLimit(3)}, ErrScanCDN},
+		// Each process reports 2 open ports, below the limit.
+		{"per process", []Option{WithCDNPortLimit(3), WithPerHostParallel(2)}, nil},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80")}, test.options...)
+		scanner := newFakeScanner(t, options...)
+
+		// The limit applies to every way of running a scan.
+		_, _, err := scanner.Run()
+		async := <-scanner.RunAsync()
+		for _, err := range []error{err, async.Err} {
+			if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+		}
+	}
+
+	if _, err := NewScanner(WithCDNPortLimit(-1)); err == nil {
+		t.Error("expected an error for a negative limit")
+	}
+}
+
+func TestCDNError(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	_, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts(spreadPorts(50)), WithCDNPortLimit(10)).Run()
+
+	// The scan stops once the limit is exceeded, counting the ports reported so far.
+	var cdnErr *CDNError
+	if !errors.As(err, &cdnErr) {
+		t.Fatalf("expected a CDNError, got %v", err)
+	}
+	if cdnErr.OpenPorts <= 10 || cdnErr.OpenPorts > 50 || cdnErr.Limit != 10 {
+		t.Errorf("expected between 11 and 50 open ports and a limit of 10, got %+v", cdnErr)
+	}
+	if want := fmt.Sprintf("Suspected CDN, no scanning: %d open ports, limit 10", cdnErr.OpenPorts); err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestWithScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    ScriptMode
+		env     map[string]string
+		service string
+	}{
+		{"nmap", ScriptsDefault, map[string]string{}, "svc22"},
+		{"no scripts", ScriptsNone, map[string]string{}, ""},
+		{"custom scripts without XML", ScriptsCustom, map[string]string{"FAKE_EXIT_EARLY": "1"}, ""},
+	}
+
+	for _, test := range tests {
+		restore := setFakeEnv(t, test.env)
+		result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80"), WithScripts(test.mode)).Run()
+		restore()
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		// Without nmap's output, the result holds the open ports RustScan reported.
+		if countPorts(result) != 2 {
+			t.Errorf("%s: expected 2 open ports, got %+v", test.name, result.Hosts)
+			continue
+		}
+		if service := result.Hosts[0].Ports[0].Service.Name; service != test.service {
+			t.Errorf("%s: expected service %q, got %q", test.name, test.service, service)
+		}
+	}
+
+	for _, options := range [][]Option{{WithScripts("all")}, {WithScripts(ScriptsNone), WithServiceInfo()}} {
+		if _, err := NewScanner(append([]Option{WithBinaryPath("rustscan")}, options...)...); err == nil {
+			t.Error("expected an error")
+		}
+	}
+}
+
+func TestSortResult(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/unsorted.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Parse(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sortResult(result)
+
+	// IP addresses are ordered numerically in their 16 byte form, which puts ::1 before
+	// the IPv4 mapped addresses, then come the hostnames.
+	var got []string
+	for _, host := range result.Hosts {
+		var ports []string
+		for _, port := range host.Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.ID, port.Protocol))
+		}
+		got = append(got, hostKey(host)+" "+strings.Join(ports, ","))
+	}
+
+	want := []string{
+		"::1 22/tcp",
+		"10.0.0.9 22/tcp,8080/tcp",
+		"10.0.0.10 53/tcp,53/udp,443/tcp",
+		"example.com 80/tcp",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestWithScanName(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithScanName("nightly")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Name != "nightly" {
+		t.Errorf("expected the name %q, got %q", "nightly", result.Name)
+	}
+}
+
+func TestWithScriptHTTPProxyInvalid(t *testing.T) {
+	for _, proxy := range []string{"proxy.example.com:3128", "ftp://proxy.example.com", "http://", "http://%zz"} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithScriptHTTPProxy(proxy)); err == nil {
+			t.Errorf("%q: expected an error", proxy)
+		}
+	}
+}
+
+func TestRunPhases(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_OUTPUT_FILE": "testdata/phases.out"})()
+
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22,80")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for idx, phase := range result.Phases {
+		names = append(names, phase.Name)
+		if idx > 0 && phase.At.Before(result.Phases[idx-1].At) {
+			t.Errorf("phase %q starts before the previous one", phase.Name)
+		}
+	}
+	if want := []string{PhasePortScan, PhaseScripts, PhaseNmap}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected phases %v, got %v", want, names)
+	}
+
+	// The phase lines do not get in the way of the XML output.
+	if len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 2 || result.Version != "7.92" {
+		t.Errorf("expected the host of the XML output, got %+v", result.Hosts)
+	}
+}
+
+// readArgsFile returns the arguments of every run of the fake, see FAKE_ARGS_FILE.
+func readArgsFile(t *testing.T, path string) [][]string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		runs = append(runs, strings.Fields(line))
+	}
+
+	return runs
+}
+
+func TestWithAutoRetryMalloc(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		options []Option
+		batches []string
+		err     error
+	}{
+		{"from the default batch size", []Option{WithAutoRetryMalloc(500)}, []string{"", "2250", "1125", "562"}, nil},
+		{"minimum reached", []Option{WithBatchSize(4000), WithAutoRetryMalloc(1500)}, []string{"4000", "2000", "1500"}, ErrMallocFailed},
+		{"without retries", []Option{WithBatchSize(4000)}, []string{"4000"}, ErrMallocFailed},
+	}
+
+	for idx, test := range tests {
+		argsFile := filepath.Join(dir, strconv.Itoa(idx))
+		restore := setFakeEnv(t, map[string]string{"FAKE_ARGS_FILE": argsFile, "FAKE_MALLOC_ABOVE": "1000"})
+
+		options := append([]Option{WithTargets("10.0.0.1"), WithPorts("22")}, test.options...)
+		_, warnings, err := newFakeScanner(t, options...).Run()
+		restore()
+
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		var batches []string
+		for _, args := range readArgsFile(t, argsFile) {
+			values, _ := extractFlag(rustScanArgs(args), "-b")
+			batches = append(batches, strings.Join(values, ","))
+		}
+		if !reflect.DeepEqual(batches, test.batches) {
+			t.Errorf("%s: expected batch sizes %q, got %q", test.name, test.batches, batches)
+		}
+
+		retries := 0
+		for _, warning := range warnings {
+			if strings.HasPrefix(warning, "malloc failed with a batch size of") {
+				retries++
+			}
+		}
+		if retries != len(test.batches)-1 {
+			t.Errorf("%s: expected a warning for each of the %d retries, got %v", test.name, len(test.batches)-1, warnings)
+		}
+	}
+
+	if _, err := NewScanner(WithAutoRetryMalloc(0)); err == nil {
+		t.Error("expected an error for a minimum batch size of 0")
+	}
+}
+
+func TestWithTimeoutBackoff(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	argsFile := filepath.Join(dir, "args")
+	defer setFakeEnv(t, map[string]string{"FAKE_ARGS_FILE": argsFile, "FAKE_MALLOC_ABOVE": "1000"})()
+
+	// The batch size is halved twice before the scan succeeds, each attempt doubling
+	// the timeout of the previous one.
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithBatchSize(4000),
+		WithAutoRetryMalloc(500), WithTimeoutBackoff(100*time.Millisecond, 2))
+	if _, _, err := scanner.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var timeouts []string
+	for _, args := range readArgsFile(t, argsFile) {
+		values, _ := extractFlag(rustScanArgs(args), "-t")
+		timeouts = append(timeouts, strings.Join(values, ","))
+	}
+	if want := []string{"100", "200", "400"}; !reflect.DeepEqual(timeouts, want) {
+		t.Errorf("expected timeouts %v, got %v", want, timeouts)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	scanner := &Scanner{}
+	WithTimeoutBackoff(1500*time.Millisecond, 1.5)(scanner)
+
+	for attempt, want := range []int{1500, 2250, 3375} {
+		if got := scanner.attemptTimeout(attempt); got != want {
+			t.Errorf("attempt %d: expected %dms, got %dms", attempt, want, got)
+		}
+	}
+
+	if got := scanner.attemptTimeout(1000); got != math.MaxInt32 {
+		t.Errorf("expected the timeout to be capped, got %d", got)
+	}
+
+	for _, option := range []Option{WithTimeoutBackoff(0, 2), WithTimeoutBackoff(time.Second, 0.5)} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), option); err == nil {
+			t.Error("expected an error for an invalid backoff")
+		}
+	}
+}
+
+func TestWithForceIPv4Conflict(t *testing.T) {
+	_, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("example.com"), WithForceIPv4(), WithIPv6())
+	if err == nil || !strings.Contains(err.Error(), "WithForceIPv4 cannot be combined with WithIPv6") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+}
+
+func TestWithTriesInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"no tries", []Option{WithTries(0)}},
+		{"negative tries", []Option{WithTries(-1)}},
+		{"with a connect behavior", []Option{WithTries(2), WithConnectBehavior(time.Second, 3)}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewScanner(append([]Option{WithBinaryPath("rustscan")}, test.options...)...); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestWithConfigPath(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "fast.toml")
+	if err := ioutil.WriteFile(path, []byte("batch_size = 1000\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithConfigPath(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := [][]string{{"-a", "10.0.0.1", "--config-path", path, "--", "-oX", "-"}}; !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	for _, missing := range []string{filepath.Join(dir, "missing.toml"), dir} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithConfigPath(missing)); !errors.Is(err, ErrConfigNotFound) {
+			t.Errorf("%s: expected ErrConfigNotFound, got %v", missing, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    string
+	}{
+		{"with no config", []Option{WithConfigPath(path), WithNoConfig()}, "WithConfigPath cannot be combined with WithNoConfig"},
+		{"set twice", []Option{WithConfigPath(path), WithConfigPath(path)}, "--config-path is set 2 times"},
+	}
+
+	for _, test := range tests {
+		_, err := NewScanner(append([]Option{WithBinaryPath("rustscan")}, test.options...)...)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestStructure(t *testing.T) {
+	run, err := Parse(Structure("10.0.0.1", "2001:db8::1", "a&b.example.com"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every target is a host that is up with its closed port 80.
+	var hosts []string
+	for _, host := range run.Hosts {
+		if host.Status.State != "up" || len(host.Ports) != 1 || host.Ports[0].ID != 80 || host.Ports[0].Status() != Closed {
+			t.Errorf("expected a host up with its closed port 80, got %+v", host)
+		}
+		hosts = append(hosts, host.Addresses[0].Addr+" "+host.Addresses[0].AddrType)
+	}
+	if want := []string{"10.0.0.1 ipv4", "2001:db8::1 ipv6", "a&b.example.com ipv4"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("expected hosts %q, got %q", want, hosts)
+	}
+	if want := (HostStats{Up: 3, Down: 0, Total: 3}); run.Stats.Hosts != want {
+		t.Errorf("expected host stats %+v, got %+v", want, run.Stats.Hosts)
+	}
+
+	run, err = Parse(Structure())
+	if err != nil || len(run.Hosts) != 0 {
+		t.Errorf("expected a run without hosts, got %+v, %v", run, err)
+	}
+}
+
+func TestRunNoOpenPorts(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"named hosts", "testdata/noports.out", []string{"10.0.0.1 up", "10.0.0.10 down"}},
+		{"targets", "testdata/noports-unnamed.out", []string{"10.0.0.1 down", "10.0.0.2 down"}},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer setFakeEnv(t, map[string]string{"FAKE_OUTPUT_FILE": test.output})()
+
+			// RustScan runs no nmap when it finds no open port, the hosts are still reported.
+			result, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2")).Run()
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+
+			var hosts []string
+			for _, host := range result.Hosts {
+				if len(host.Ports) != 0 {
+					t.Errorf("%s: expected no ports, got %+v", test.name, host.Ports)
+				}
+				hosts = append(hosts, host.Addresses[0].Addr+" "+host.Status.State)
+			}
+			if !reflect.DeepEqual(hosts, test.want) {
+				t.Errorf("%s: expected %q, got %q", test.name, test.want, hosts)
+			}
+		}()
+	}
+}
+
+func TestScanTimeoutError(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": filepath.Join(dir, "never")})()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// The fake reports the 4 open ports, then never ends.
+	_, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2"), WithPorts("22,80"), WithContext(ctx)).Run()
+
+	var timeoutErr *ScanTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a ScanTimeoutError, got %v", err)
+	}
+	if !errors.Is(err, ErrScanTimeout) {
+		t.Errorf("expected the error to match ErrScanTimeout, got %v", err)
+	}
+	if timeoutErr.OpenPorts != 4 {
+		t.Errorf("expected 4 open ports, got %d", timeoutErr.OpenPorts)
+	}
+	if timeoutErr.Elapsed < 300*time.Millisecond || timeoutErr.Elapsed > 10*time.Second {
+		t.Errorf("expected the time until the context was done, got %v", timeoutErr.Elapsed)
+	}
+	if !strings.Contains(err.Error(), "4 open ports found") {
+		t.Errorf("expected the open ports in the message, got %q", err)
+	}
+}
+
+func TestWithMaxRuntime(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	defer setFakeEnv(t, map[string]string{"FAKE_WAIT_FILE": filepath.Join(dir, "never")})()
+
+	// The scan stops with whichever limit comes first, the runtime being counted from
+	// the start of each scan rather than once for the scanner.
+	tests := []struct {
+		name    string
+		ctx     time.Duration
+		runtime time.Duration
+		runs    int
+		limit   time.Duration
+	}{
+		{"runtime only", 0, 200 * time.Millisecond, 2, 200 * time.Millisecond},
+		{"runtime first", time.Minute, 200 * time.Millisecond, 2, 200 * time.Millisecond},
+		{"context first", 200 * time.Millisecond, time.Minute, 1, 200 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		options := []Option{WithTargets("10.0.0.1"), WithPorts("22"), WithMaxRuntime(test.runtime)}
+		if test.ctx > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), test.ctx)
+			defer cancel()
+			options = append(options, WithContext(ctx))
+		}
+		scanner := newFakeScanner(t, options...)
+
+		for run := 1; run <= test.runs; run++ {
+			started := time.Now()
+			_, _, err := scanner.Run()
+
+			var timeoutErr *ScanTimeoutError
+			if !errors.As(err, &timeoutErr) {
+				t.Fatalf("%s, run %d: expected a ScanTimeoutError, got %v", test.name, run, err)
+			}
+			if elapsed := time.Since(started); elapsed < test.limit || elapsed > 10*time.Second {
+				t.Errorf("%s, run %d: expected the scan to stop after %v, took %v", test.name, run, test.limit, elapsed)
+			}
+		}
+	}
+
+	for _, d := range []time.Duration{0, -time.Second} {
+		if _, err := NewScanner(WithBinaryPath("rustscan"), WithMaxRuntime(d)); err == nil {
+			t.Errorf("%v: expected an error", d)
+		}
+	}
+}
+
+func TestAnalyzeWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		warnings []string
+		want     error
+	}{
+		{"none", nil, nil},
+		{"harmless", []string{"[!] File limit is lower than default batch size."}, nil},
+		{"malloc", []string{"Malloc Failed!"}, ErrMallocFailed},
+		{"raw sockets", []string{"You requested a scan type which requires root privileges."}, ErrPermissionDenied},
+		{"not permitted", []string{"Error: Operation not permitted"}, ErrPermissionDenied},
+		{"permission denied", []string{"Error: Permission denied (os error 13)"}, ErrPermissionDenied},
+		{"no targets", []string{"WARNING: No targets were specified, so 0 hosts scanned."}, ErrNoTargets},
+		{"unresolved", []string{"[!] No IPs could be resolved, aborting scan."}, ErrNoTargets},
+		{"panic", []string{"thread 'main' panicked at 'index out of bounds', src/main.rs:12:5"}, ErrRustScanPanic},
+		{"backtrace", []string{"stack backtrace:", "   0: rust_begin_unwind"}, ErrRustScanPanic},
+		// The first known error in priority order wins, whatever the order of the warnings.
+		{"priority", []string{"thread 'main' panicked at 'x'", "Malloc Failed!"}, ErrMallocFailed},
+	}
+
+	for _, test := range tests {
+		if err := analyzeWarnings(test.warnings); err != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestRunRustScanPanic(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_STDERR": "thread 'main' panicked at 'attempt to subtract with overflow', src/scanner/mod.rs:96:27"})()
+
+	_, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22")).Run()
+	if !errors.Is(err, ErrRustScanPanic) {
+		t.Errorf("expected ErrRustScanPanic, got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "panicked at") {
+		t.Errorf("expected the panic among the warnings, got %v", warnings)
+	}
+}
+
+func TestNmapError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{"Error resolving name \"nonexistent.invalid\"", ErrResolveName},
+		{"Failed to resolve \"nonexistent.invalid\".", ErrResolveName},
+		{"dnet: Failed to open device eth0", ErrPermissionDenied},
+		{"You requested a scan type which requires root privileges.", ErrPermissionDenied},
+		{"caught SIGINT signal, cleaning up", ErrNmapInterrupted},
+		{"Scan interrupted by user", ErrNmapInterrupted},
+		{"QUITTING!", ErrNmapFailed},
+	}
+
+	for _, test := range tests {
+		err := nmapError(test.message)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%q: expected %v, got %v", test.message, test.want, err)
+		}
+	}
+
+	// An unknown error keeps nmap's message.
+	if err := nmapError("QUITTING!"); !strings.Contains(err.Error(), "QUITTING!") {
+		t.Errorf("expected nmap's message in the error, got %q", err)
+	}
+}
+
+func TestRunNmapError(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_OUTPUT_FILE": "testdata/nmaperror.xml"})()
+
+	// The result is returned along with the error nmap reported.
+	result, _, err := newFakeScanner(t, WithTargets("10.0.0.1", "10.0.0.2")).Run()
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied, got %v", err)
+	}
+	if result == nil || len(result.Hosts) != 2 {
+		t.Errorf("expected the hosts of the output, got %+v", result)
+	}
+}
+
+// scanLines returns the lines of a scanner.
+func scanLines(scanner bufio.Scanner) []string {
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines
+}
+
+func TestGetStdoutStderr(t *testing.T) {
+	restore := setFakeEnv(t, map[string]string{"FAKE_STDOUT": "[~] custom line", "FAKE_STDERR": "[!] error line"})
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"))
+	if lines := scanLines(scanner.GetStdout()); len(lines) != 0 {
+		t.Errorf("expected no output before a scan, got %q", lines)
+	}
+
+	if _, _, err := scanner.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restore()
+
+	// The output of RustScan is kept, without nmap's XML.
+	stdout := scanLines(scanner.GetStdout())
+	if !containsString(stdout, "[~] custom line") || !containsString(stdout, "Open \x1b[35m10.0.0.1:22\x1b[0m") {
+		t.Errorf("expected the lines of RustScan, got %q", stdout)
+	}
+	for _, line := range stdout {
+		if strings.Contains(line, "<host") || strings.Contains(line, "<?xml") {
+			t.Errorf("unexpected XML line %q", line)
+		}
+	}
+	if stderr := scanLines(scanner.GetStderr()); !reflect.DeepEqual(stderr, []string{"[!] error line"}) {
+		t.Errorf("expected the error line, got %q", stderr)
+	}
+
+	// The output of the previous scan is forgotten when a new one runs.
+	defer setFakeEnv(t, map[string]string{})()
+	if _, _, err := scanner.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if containsString(scanLines(scanner.GetStdout()), "[~] custom line") || len(scanLines(scanner.GetStderr())) != 0 {
+		t.Error("expected the output of the previous scan to be forgotten")
+	}
+}
+
+func TestRunWarningsWithStderr(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// Without nice(1) on the path, the niceness cannot be set on any platform.
+	path := os.Getenv("PATH")
+	if err := os.Setenv("PATH", dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", path)
+
+	defer setFakeEnv(t, map[string]string{"FAKE_STDERR": "[!] warning from RustScan"})()
+
+	// The warnings of the scanner are kept along with the error output of RustScan.
+	_, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithNiceness(5)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "unable to set the niceness") || warnings[1] != "[!] warning from RustScan" {
+		t.Errorf("expected the niceness warning and the error output, got %q", warnings)
+	}
+}
@@ -0,0 +1,384 @@
+package RustScan
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RustScan colors its output, which wraps the interesting parts of a line in escape codes.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func stripANSI(line string) string {
+	return ansiEscape.ReplaceAllString(line, "")
+}
+
+// Phase is a stage of a scan and the time at which it started.
+type Phase struct {
+	Name string    `json:"name"`
+	At   time.Time `json:"at"`
+}
+
+// Enumerates the phases of a scan.
+const (
+	// PhasePortScan is RustScan's port scan, which starts with the process.
+	PhasePortScan = "port scan"
+	// PhaseScripts starts when RustScan prints "Starting Script(s)".
+	PhaseScripts = "scripts"
+	// PhaseNmap starts when nmap prints its "Starting Nmap" banner.
+	PhaseNmap = "nmap"
+)
+
+// parsePhase recognizes the lines RustScan prints when a scan enters a new phase.
+func parsePhase(line string) (string, bool) {
+	switch {
+	case strings.Contains(line, "Starting Script(s)"):
+		return PhaseScripts, true
+	case strings.Contains(line, "Starting Nmap"):
+		return PhaseNmap, true
+	default:
+		return "", false
+	}
+}
+
+// taskProgress matches the progress of the current nmap task in its XML output, which
+// nmap writes periodically with --stats-every.
+var taskProgress = regexp.MustCompile(`<taskprogress [^>]*percent="([0-9.]+)"`)
+
+// parseTaskProgress returns the percentage of the current nmap task done.
+func parseTaskProgress(line string) (float32, bool) {
+	match := taskProgress.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+
+	percent, err := strconv.ParseFloat(match[1], 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return float32(percent), true
+}
+
+// noOpenPorts matches the line RustScan prints for a host it found no open port on,
+// such as "Looks like I didn't find any open ports for 10.0.0.1. This is usually caused
+// by a high batch size.", and captures the address of the host.
+var noOpenPorts = regexp.MustCompile(`Looks like I didn't find any open ports(?: for (\S+?)\.?(?:\s|$))?`)
+
+// parseNoOpenPorts parses the line RustScan prints for a host it found no open port
+// on. The address is empty when the line does not name the host.
+func parseNoOpenPorts(line string) (string, bool) {
+	match := noOpenPorts.FindStringSubmatch(stripANSI(line))
+	if match == nil {
+		return "", false
+	}
+
+	return match[1], true
+}
+
+// UlimitWarning tells that RustScan warned that the open file limit is too low for its
+// batch size, which it then reduced, making the scan slower and possibly less complete.
+// The scan still succeeded; raising the limit with WithUlimit avoids it next time.
+type UlimitWarning struct {
+	// Suggested is the limit RustScan suggested to set with WithUlimit, or 0 if it did
+	// not suggest one.
+	Suggested int `json:"suggested,omitempty"`
+	// Message is the warning RustScan printed, without its colors.
+	Message string `json:"message"`
+}
+
+// ulimitWarning matches the warnings RustScan prints when the open file limit is too low
+// for its batch size, such as "Your file limit is very small, which negatively impacts
+// RustScan's speed. Use the Docker image, or up the Ulimit with '--ulimit 5000'." or
+// "File limit is lower than default batch size. Consider upping with --ulimit.".
+var ulimitWarning = regexp.MustCompile(`(?i)file limit is (?:very small|lower than)`)
+
+// ulimitSuggestion captures the limit a ulimit warning suggests, if any.
+var ulimitSuggestion = regexp.MustCompile(`--ulimit'?\s+'?(\d+)`)
+
+// parseUlimitWarning parses a warning of RustScan about the open file limit, and returns
+// the line without its colors and the limit it suggests, 0 if it does not suggest one.
+func parseUlimitWarning(line string) (warning string, suggested int, ok bool) {
+	line = strings.TrimSpace(stripANSI(line))
+	if !ulimitWarning.MatchString(line) {
+		return "", 0, false
+	}
+
+	if match := ulimitSuggestion.FindStringSubmatch(line); match != nil {
+		suggested, _ = strconv.Atoi(match[1])
+	}
+
+	return line, suggested, true
+}
+
+// openPort is a port RustScan reported open on its stdout.
+type openPort struct {
+	addr string
+	port uint16
+}
+
+// parseOpenLine parses the lines RustScan prints for every open port it discovers,
+// such as "Open 192.168.1.1:80" or "Open [::1]:80".
+func parseOpenLine(line string) (openPort, bool) {
+	line = strings.TrimSpace(stripANSI(line))
+	if !strings.HasPrefix(line, "Open ") {
+		return openPort{}, false
+	}
+
+	host, port, err := net.SplitHostPort(strings.TrimSpace(strings.TrimPrefix(line, "Open ")))
+	if err != nil {
+		return openPort{}, false
+	}
+
+	id, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return openPort{}, false
+	}
+
+	return openPort{addr: host, port: uint16(id)}, true
+}
+
+// lineBuffer splits chunks of output into complete lines.
+type lineBuffer struct {
+	pending string
+}
+
+// write adds a chunk of output and returns the lines it completed.
+func (b *lineBuffer) write(chunk string) []string {
+	b.pending += chunk
+
+	var lines []string
+	for {
+		idx := strings.IndexByte(b.pending, '\n')
+		if idx < 0 {
+			return lines
+		}
+
+		lines = append(lines, strings.TrimSuffix(b.pending[:idx], "\r"))
+		b.pending = b.pending[idx+1:]
+	}
+}
+
+// openPortsRun builds a result from the open ports RustScan reported, for scans that
+// stop before nmap produced its XML output.
+func openPortsRun(ports []openPort) *Run {
+	result := &Run{Scanner: "rustscan"}
+	hostIndex := make(map[string]int)
+
+	for _, port := range ports {
+		idx, ok := hostIndex[port.addr]
+		if !ok {
+			idx = len(result.Hosts)
+			hostIndex[port.addr] = idx
+			result.Hosts = append(result.Hosts, newHost(port.addr, Status{State: "up"}))
+		}
+
+		result.Hosts[idx].Ports = append(result.Hosts[idx].Ports, Port{
+			ID:       port.port,
+			Protocol: "tcp",
+			State:    State{State: string(Open)},
+		})
+	}
+
+	result.Stats.Hosts = HostStats{Up: len(result.Hosts), Total: len(result.Hosts)}
+
+	return result
+}
+
+// noOpenPortsRun builds the result of a scan that found no open port, which RustScan
+// does not run nmap for: the hosts have no ports, and are up when a line of the output
+// tells so, down otherwise since RustScan reported no sign of them.
+func noOpenPortsRun(hosts []string, upLines []string) *Run {
+	result := &Run{Scanner: "rustscan"}
+
+	for _, addr := range hosts {
+		status := Status{State: "down", Reason: "no-response"}
+		for _, line := range upLines {
+			if mentionsAddress(line, addr) {
+				status = Status{State: "up"}
+				result.Stats.Hosts.Up++
+				break
+			}
+		}
+
+		result.Hosts = append(result.Hosts, newHost(addr, status))
+	}
+
+	result.Stats.Hosts.Total = len(result.Hosts)
+	result.Stats.Hosts.Down = result.Stats.Hosts.Total - result.Stats.Hosts.Up
+
+	return result
+}
+
+// mentionsAddress reports whether a line names an address, as a word of its own so that
+// 10.0.0.1 is not found in 10.0.0.10. The punctuation around the word is left out, colons
+// only when that is not enough, since they are part of IPv6 addresses such as ::1.
+func mentionsAddress(line, addr string) bool {
+	addr = strings.Trim(addr, "[]")
+	for _, word := range strings.Fields(stripANSI(line)) {
+		if strings.Trim(word, "()[],;.!") == addr || strings.Trim(word, "()[],:;.!") == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newHost returns a host with a single address and no ports.
+func newHost(addr string, status Status) Host {
+	addrType := "ipv4"
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		addrType = "ipv6"
+	}
+
+	return Host{
+		Status:    status,
+		Addresses: []Address{{Addr: addr, AddrType: addrType}},
+	}
+}
+
+// retryMarkers are the parts of the lines nmap writes when it retransmits probes or
+// gives up on a port after too many retransmissions.
+var retryMarkers = []string{
+	"retransmission",
+	"increasing send delay",
+	"giving up on port",
+	"retrying",
+}
+
+// retryCounter counts the output lines reporting retries, and calls exceeded once
+// their number goes over the budget.
+type retryCounter struct {
+	budget   int
+	count    int
+	lines    lineBuffer
+	exceeded func()
+}
+
+func (c *retryCounter) Write(p []byte) (int, error) {
+	for _, line := range c.lines.write(string(p)) {
+		line = strings.ToLower(line)
+		for _, marker := range retryMarkers {
+			if strings.Contains(line, marker) {
+				c.count++
+				if c.count == c.budget+1 {
+					c.exceeded()
+				}
+				break
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+// xmlStream decodes nmap's XML output line by line as RustScan prints it, so that the
+// output does not have to be kept in memory as a whole before being parsed. The raw XML
+// is kept for ToFile and ToReader unless the stream is lean, see WithMinimalMemory.
+type xmlStream struct {
+	writer      *io.PipeWriter
+	result      chan xmlStreamResult
+	noOpenPorts bool
+	// quietHosts are the addresses RustScan found no open port on, and targets the
+	// ones it scans, which stand in for them if it does not name them. upLines are the
+	// lines telling that a host is up.
+	quietHosts []string
+	targets    []string
+	upLines    []string
+
+	lean bool
+	raw  bytes.Buffer
+
+	// onHost is called from the decoder with every host as soon as it is decoded, if set.
+	onHost func(Host)
+}
+
+type xmlStreamResult struct {
+	run *Run
+	err error
+}
+
+// feed passes a line of RustScan's output to the decoder, starting it when the XML
+// output begins.
+func (x *xmlStream) feed(line string) {
+	if x.writer == nil {
+		idx := strings.Index(line, "<?xml ")
+		if idx < 0 {
+			if addr, ok := parseNoOpenPorts(line); ok {
+				x.noOpenPorts = true
+				if addr != "" {
+					x.quietHosts = append(x.quietHosts, addr)
+				}
+			}
+			if strings.Contains(strings.ToLower(line), "is up") {
+				x.upLines = append(x.upLines, line)
+			}
+			return
+		}
+
+		reader, writer := io.Pipe()
+		x.writer = writer
+		x.result = make(chan xmlStreamResult, 1)
+		go func() {
+			run, err := parseStream(reader, x.onHost)
+			// Drain the output the decoder did not read, so that feed never blocks.
+			_, _ = io.Copy(ioutil.Discard, reader)
+			x.result <- xmlStreamResult{run: run, err: err}
+		}()
+
+		line = line[idx:]
+	}
+
+	_, _ = io.WriteString(x.writer, line+"\n")
+	if !x.lean {
+		x.raw.WriteString(line + "\n")
+	}
+}
+
+// started reports whether the XML output began.
+func (x *xmlStream) started() bool {
+	return x.writer != nil
+}
+
+// rawXML returns the XML output fed so far, nil for a lean stream.
+func (x *xmlStream) rawXML() []byte {
+	if x.lean {
+		return nil
+	}
+
+	return extractXML(x.raw.String())
+}
+
+// close ends the output and returns the decoded run.
+func (x *xmlStream) close() (*Run, error) {
+	if x.writer == nil {
+		if x.noOpenPorts {
+			hosts := x.quietHosts
+			if len(hosts) == 0 {
+				hosts = x.targets
+			}
+			return noOpenPortsRun(hosts, x.upLines), nil
+		}
+		return Parse(nil)
+	}
+
+	_ = x.writer.Close()
+	result := <-x.result
+	if result.run != nil {
+		result.run.rawXML = x.rawXML()
+	}
+
+	return result.run, result.err
+}
+
+// abort stops the decoder when the output will not be parsed.
+func (x *xmlStream) abort() {
+	if x.writer != nil {
+		_ = x.writer.CloseWithError(io.ErrUnexpectedEOF)
+	}
+}
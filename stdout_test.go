@@ -0,0 +1,199 @@
+package RustScan
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseUlimitWarning(t *testing.T) {
+	tests := []struct {
+		line      string
+		ok        bool
+		suggested int
+	}{
+		{"\x1b[31m[!]\x1b[0m Your file limit is very small, which negatively impacts RustScan's speed. Use the Docker image, or up the Ulimit with '--ulimit 5000'.", true, 5000},
+		{"[!] File limit is lower than default batch size. Consider upping with --ulimit. May cause harm to sensitive servers", true, 0},
+		{"File limit higher than batch size. Can increase speed by increasing batch size '-b 1024'.", false, 0},
+		{"Open 10.0.0.1:22", false, 0},
+	}
+
+	for _, test := range tests {
+		_, suggested, ok := parseUlimitWarning(test.line)
+		if ok != test.ok || suggested != test.suggested {
+			t.Errorf("%q: expected %v %d, got %v %d", test.line, test.ok, test.suggested, ok, suggested)
+		}
+	}
+}
+
+func TestParsePhase(t *testing.T) {
+	tests := []struct {
+		line  string
+		phase string
+		ok    bool
+	}{
+		{"[~] Starting Script(s)", PhaseScripts, true},
+		{"\x1b[34m[~]\x1b[0m Starting Script(s)", PhaseScripts, true},
+		{"[~] Starting Nmap 7.92 ( https://nmap.org ) at 2021-12-07 15:34 CST", PhaseNmap, true},
+		{"Open 10.0.0.1:22", "", false},
+		{`[>] Script to be run Some("nmap -vvv -p {{port}} {{ip}}")`, "", false},
+	}
+
+	for _, test := range tests {
+		phase, ok := parsePhase(test.line)
+		if phase != test.phase || ok != test.ok {
+			t.Errorf("%q: expected %q %v, got %q %v", test.line, test.phase, test.ok, phase, ok)
+		}
+	}
+}
+
+// benchmarkOutput is the output of a scan of 1000 hosts with 10 open ports each, as
+// RustScan prints it.
+var benchmarkOutput = func() []string {
+	lines := []string{
+		"[~] Starting Script(s)",
+		"[~] Starting Nmap 7.92 ( https://nmap.org ) at 2021-12-07 15:34 CST",
+		`[~] <?xml version="1.0" encoding="UTF-8"?>`,
+		`<nmaprun scanner="nmap" args="nmap -oX -" start="1638862444" version="7.92" xmloutputversion="1.05">`,
+	}
+	for host := 0; host < 1000; host++ {
+		lines = append(lines, fmt.Sprintf(`<host starttime="1638862444" endtime="1638862444"><status state="up" reason="syn-ack" reason_ttl="0"/><address addr="10.0.%d.%d" addrtype="ipv4"/><ports>`, host/256, host%256))
+		for port := 1; port <= 10; port++ {
+			lines = append(lines, fmt.Sprintf(`<port protocol="tcp" portid="%d"><state state="open" reason="syn-ack" reason_ttl="0"/><service name="svc" method="table" conf="3"/></port>`, port))
+		}
+		lines = append(lines, `</ports></host>`)
+	}
+
+	return append(lines, `<runstats><finished time="1638862445" timestr="x" elapsed="1" exit="success"/><hosts up="1000" down="0" total="1000"/></runstats></nmaprun>`)
+}()
+
+// BenchmarkXMLStream compares decoding the output of a scan while keeping the raw XML,
+// as Run does by default, to the lean decoding of WithMinimalMemory. The retained-B/op
+// metric is the size of the raw XML the result holds on to.
+func BenchmarkXMLStream(b *testing.B) {
+	for _, lean := range []bool{false, true} {
+		name := "raw"
+		if lean {
+			name = "lean"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			var retained int
+			for i := 0; i < b.N; i++ {
+				stream := &xmlStream{lean: lean}
+				for _, line := range benchmarkOutput {
+					stream.feed(line)
+				}
+
+				run, err := stream.close()
+				if err != nil || len(run.Hosts) != 1000 {
+					b.Fatalf("unexpected result: %v", err)
+				}
+				retained = len(run.rawXML)
+			}
+
+			b.ReportMetric(float64(retained), "retained-B/op")
+		})
+	}
+}
+
+func TestLineBuffer(t *testing.T) {
+	var buffer lineBuffer
+
+	var lines []string
+	for _, chunk := range []string{"Open 10.0", ".0.1:22\nOpen 10.0.0.1:80\r\n", "", "[~] Starting", " Nmap\n\n<?xml"} {
+		lines = append(lines, buffer.write(chunk)...)
+	}
+
+	if want := []string{"Open 10.0.0.1:22", "Open 10.0.0.1:80", "[~] Starting Nmap", ""}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %q, got %q", want, lines)
+	}
+	if buffer.pending != "<?xml" {
+		t.Errorf("expected the incomplete line to be pending, got %q", buffer.pending)
+	}
+}
+
+func TestParseTaskProgress(t *testing.T) {
+	tests := []struct {
+		line    string
+		percent float32
+		ok      bool
+	}{
+		{`<taskprogress task="SYN Stealth Scan" time="1638862444" percent="12.50" remaining="35" etc="1638862480"/>`, 12.5, true},
+		{`<taskprogress task="Service scan" time="1638862444" percent="100.00"/>`, 100, true},
+		{`<taskbegin task="Service scan" time="1638862444"/>`, 0, false},
+		{`Open 10.0.0.1:22`, 0, false},
+	}
+
+	for _, test := range tests {
+		percent, ok := parseTaskProgress(test.line)
+		if ok != test.ok || percent != test.percent {
+			t.Errorf("%q: expected %v %v, got %v %v", test.line, test.percent, test.ok, percent, ok)
+		}
+	}
+}
+
+func TestParseNoOpenPorts(t *testing.T) {
+	tests := []struct {
+		line string
+		addr string
+		ok   bool
+	}{
+		{"[!] Looks like I didn't find any open ports for 10.0.0.1. This is usually caused by a high batch size.", "10.0.0.1", true},
+		{"\x1b[31m[!]\x1b[0m Looks like I didn't find any open ports for example.com.", "example.com", true},
+		{"Looks like I didn't find any open ports for ::1", "::1", true},
+		{"[!] Looks like I didn't find any open ports!", "", true},
+		{"Open 10.0.0.1:22", "", false},
+	}
+
+	for _, test := range tests {
+		addr, ok := parseNoOpenPorts(test.line)
+		if addr != test.addr || ok != test.ok {
+			t.Errorf("%q: expected %q %v, got %q %v", test.line, test.addr, test.ok, addr, ok)
+		}
+	}
+}
+
+func TestMentionsAddress(t *testing.T) {
+	tests := []struct {
+		line string
+		addr string
+		want bool
+	}{
+		{"10.0.0.1 is up", "10.0.0.1", true},
+		{"Host (10.0.0.1) is up.", "10.0.0.1", true},
+		{"\x1b[32m10.0.0.1\x1b[0m is up", "10.0.0.1", true},
+		{"10.0.0.10 is up", "10.0.0.1", false},
+		{"[::1] is up", "::1", true},
+		{"::1 is up", "[::1]", true},
+		{"10.0.0.1: host is up", "10.0.0.1", true},
+		{"2001:db8:: is up", "2001:db8::", true},
+		{"2001:db8::1 is up", "1", false},
+	}
+
+	for _, test := range tests {
+		if got := mentionsAddress(test.line, test.addr); got != test.want {
+			t.Errorf("%q, %q: expected %v, got %v", test.line, test.addr, test.want, got)
+		}
+	}
+}
+
+func TestNoOpenPortsRun(t *testing.T) {
+	run := noOpenPortsRun([]string{"10.0.0.1", "10.0.0.2", "::1"}, []string{"10.0.0.2 is up", "::1 is up"})
+
+	var hosts []string
+	for _, host := range run.Hosts {
+		if len(host.Ports) != 0 {
+			t.Errorf("expected no ports, got %+v", host.Ports)
+		}
+		hosts = append(hosts, fmt.Sprintf("%s %s %s", host.Addresses[0].Addr, host.Addresses[0].AddrType, host.Status.State))
+	}
+	if want := []string{"10.0.0.1 ipv4 down", "10.0.0.2 ipv4 up", "::1 ipv6 up"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("expected %q, got %q", want, hosts)
+	}
+	if want := (HostStats{Up: 2, Down: 1, Total: 3}); run.Stats.Hosts != want {
+		t.Errorf("expected host stats %+v, got %+v", want, run.Stats.Hosts)
+	}
+}
@@ -0,0 +1,77 @@
+package RustScan
+
+import "sync"
+
+// streamTeeBuffer is the number of chunks of output a streamer may fall behind by before
+// output is dropped.
+const streamTeeBuffer = 256
+
+// streamTee copies the output of a scan to a Streamer from its own goroutine, so that a
+// slow Streamer does not stall the scan. When the Streamer falls too far behind, the
+// output it could not keep up with is dropped rather than waited for.
+type streamTee struct {
+	chunks  chan []byte
+	mutex   sync.Mutex
+	closed  bool
+	dropped int
+}
+
+// newStreamTee starts copying to streamer, or returns nil if there is no streamer.
+func newStreamTee(streamer Streamer) *streamTee {
+	if streamer == nil {
+		return nil
+	}
+
+	t := &streamTee{chunks: make(chan []byte, streamTeeBuffer)}
+	go func() {
+		for chunk := range t.chunks {
+			_, _ = streamer.Write(chunk)
+		}
+	}()
+
+	return t
+}
+
+// Write queues a copy of p for the Streamer and never blocks. It always succeeds, so
+// that it can be used in an io.MultiWriter.
+func (t *streamTee) Write(p []byte) (int, error) {
+	if t == nil || len(p) == 0 {
+		return len(p), nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return len(p), nil
+	}
+
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+
+	select {
+	case t.chunks <- chunk:
+	default:
+		t.dropped += len(p)
+	}
+
+	return len(p), nil
+}
+
+// close stops queueing output and returns the number of bytes that were dropped. The
+// output already queued is still written to the Streamer, without waiting for it.
+func (t *streamTee) close() (dropped int) {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.chunks)
+	}
+
+	return t.dropped
+}
@@ -0,0 +1,103 @@
+package RustScan
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStreamer records the output streamed to it, after release is closed if set.
+type recordingStreamer struct {
+	mutex   sync.Mutex
+	data    bytes.Buffer
+	release chan struct{}
+}
+
+func (r *recordingStreamer) Write(d []byte) (int, error) {
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.data.Write(d)
+}
+
+func (r *recordingStreamer) Bytes() []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]byte(nil), r.data.Bytes()...)
+}
+
+// waitFor waits for the streamer to receive output containing want, since Run does not
+// wait for streamers to catch up.
+func (r *recordingStreamer) waitFor(t *testing.T, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(string(r.Bytes()), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q to be streamed, got %q", want, r.Bytes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithStdoutStreamer(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{"FAKE_STDERR": "[!] warning from RustScan"})()
+
+	stdout, stderr := &recordingStreamer{}, &recordingStreamer{}
+	result, warnings, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithPorts("22"), WithStdoutStreamer(stdout), WithStderrStreamer(stderr)).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The output is streamed and still parsed.
+	stdout.waitFor(t, "10.0.0.1:22")
+	stdout.waitFor(t, "</nmaprun>")
+	stderr.waitFor(t, "[!] warning from RustScan")
+	if len(result.Hosts) != 1 || len(result.Hosts[0].Ports) != 1 {
+		t.Errorf("expected the host and its port, got %+v", result.Hosts)
+	}
+	if !containsString(warnings, "[!] warning from RustScan") {
+		t.Errorf("expected the error output among the warnings, got %v", warnings)
+	}
+}
+
+func TestStreamTeeDrops(t *testing.T) {
+	streamer := &recordingStreamer{release: make(chan struct{})}
+	tee := newStreamTee(streamer)
+
+	// A streamer that does not keep up does not block the writes, the chunks it
+	// falls too far behind on are dropped.
+	chunk := []byte("Open 10.0.0.1:22\n")
+	extra := 10
+	for idx := 0; idx < streamTeeBuffer+extra; idx++ {
+		if n, err := tee.Write(chunk); n != len(chunk) || err != nil {
+			t.Fatalf("expected the write to succeed, got %d, %v", n, err)
+		}
+	}
+
+	dropped := tee.close()
+	if dropped == 0 || dropped > extra*len(chunk) || dropped%len(chunk) != 0 {
+		t.Errorf("expected up to %d dropped chunks, got %d bytes", extra, dropped)
+	}
+
+	// The chunks queued before close are still streamed.
+	close(streamer.release)
+	want := strings.Repeat(string(chunk), streamTeeBuffer+extra-dropped/len(chunk))
+	streamer.waitFor(t, want)
+
+	if n, err := tee.Write(chunk); n != len(chunk) || err != nil {
+		t.Errorf("expected a write after close to be ignored, got %d, %v", n, err)
+	}
+
+	var none *streamTee
+	if n, err := none.Write(chunk); n != len(chunk) || err != nil || none.close() != 0 {
+		t.Error("expected a nil tee to accept writes")
+	}
+}
@@ -0,0 +1,254 @@
+package RustScan
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// masscanRecord is a single entry of masscan's JSON output (-oJ).
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+}
+
+// Older masscan releases leave a comma after the last record of the array.
+var masscanTrailingComma = regexp.MustCompile(`,\s*\]\s*$`)
+
+// TargetsFromMasscanJSON extracts the open host:port pairs from masscan's JSON output,
+// either the array written by -oJ or newline delimited records. The pairs keep the
+// order of the input and duplicates are removed.
+func TargetsFromMasscanJSON(r io.Reader) ([]string, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	content = bytes.TrimSpace(content)
+
+	var records []masscanRecord
+	if bytes.HasPrefix(content, []byte("[")) {
+		content = masscanTrailingComma.ReplaceAll(content, []byte("]"))
+		if err := json.Unmarshal(content, &records); err != nil {
+			return nil, fmt.Errorf("unable to parse masscan output: %w", err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for {
+			var record masscanRecord
+			err := decoder.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse masscan output: %w", err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	var pairs pairList
+	for _, record := range records {
+		for _, port := range record.Ports {
+			if port.Status != "" && port.Status != "open" {
+				continue
+			}
+			pairs.add(record.IP, strconv.Itoa(port.Port))
+		}
+	}
+
+	return pairs.pairs, nil
+}
+
+// TargetsFromNessusCSV extracts the host:port pairs from a Nessus CSV export. The Host
+// and Port columns are located through the header row, and findings that are not tied
+// to a port (port 0) are skipped. The pairs keep the order of the input and duplicates
+// are removed.
+func TargetsFromNessusCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Nessus CSV header: %w", err)
+	}
+
+	hostColumn, portColumn := -1, -1
+	for idx, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "host":
+			hostColumn = idx
+		case "port":
+			portColumn = idx
+		}
+	}
+
+	if hostColumn < 0 || portColumn < 0 {
+		return nil, errors.New("Nessus CSV is missing the Host or Port column")
+	}
+
+	var pairs pairList
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Nessus CSV: %w", err)
+		}
+
+		if len(record) <= hostColumn || len(record) <= portColumn {
+			continue
+		}
+
+		host, port := strings.TrimSpace(record[hostColumn]), strings.TrimSpace(record[portColumn])
+		if host == "" || port == "" || port == "0" {
+			continue
+		}
+
+		pairs.add(host, port)
+	}
+
+	return pairs.pairs, nil
+}
+
+// pairList is an ordered list of unique host:port pairs.
+type pairList struct {
+	pairs []string
+	seen  map[string]bool
+}
+
+func (p *pairList) add(host, port string) {
+	pair := net.JoinHostPort(host, port)
+	if p.seen[pair] {
+		return
+	}
+
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+
+	p.seen[pair] = true
+	p.pairs = append(p.pairs, pair)
+}
+
+// hostPortGroup is a set of hosts that are scanned on the same ports.
+type hostPortGroup struct {
+	hosts []string
+	ports []int
+}
+
+// groupHostPortPairs parses host:port pairs and groups the hosts by their set of ports,
+// so that each group can be scanned by a single RustScan process.
+func groupHostPortPairs(pairs []string) ([]hostPortGroup, error) {
+	var hosts []string
+	hostPorts := make(map[string][]int)
+
+	for _, pair := range pairs {
+		host, portStr, err := net.SplitHostPort(strings.TrimSpace(pair))
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid host:port pair %q", pair)
+		}
+
+		port, err := parsePort(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host:port pair %q: %w", pair, err)
+		}
+
+		if _, ok := hostPorts[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		if !containsInt(hostPorts[host], port) {
+			hostPorts[host] = append(hostPorts[host], port)
+		}
+	}
+
+	var groups []hostPortGroup
+	groupIndex := make(map[string]int)
+
+	for _, host := range hosts {
+		ports := hostPorts[host]
+		sort.Ints(ports)
+
+		key := formatPorts(ports)
+		idx, ok := groupIndex[key]
+		if !ok {
+			idx = len(groups)
+			groupIndex[key] = idx
+			groups = append(groups, hostPortGroup{ports: ports})
+		}
+
+		groups[idx].hosts = append(groups[idx].hosts, host)
+	}
+
+	return groups, nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, elem := range list {
+		if elem == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractTargets removes the addresses (-a) from the RustScan arguments: the values
+// following every -a flag up to the next flag, each of which may be a comma separated
+// list. It returns the addresses, the remaining arguments and the position of the first
+// -a flag in them, or -1 when there is none.
+func extractTargets(args []string) (targets, rest []string, at int) {
+	at = -1
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		if args[i] != "-a" {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if at < 0 {
+			at = len(rest)
+		}
+
+		for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			for _, target := range strings.Split(args[i], ",") {
+				if target = strings.TrimSpace(target); target != "" {
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+
+	return targets, rest, at
+}
+
+// insertTargets returns a copy of the arguments with the addresses inserted as a single
+// -a flag at the given position.
+func insertTargets(args []string, at int, targets []string) []string {
+	withTargets := make([]string, 0, len(args)+2)
+	withTargets = append(withTargets, args[:at]...)
+	withTargets = append(withTargets, "-a", strings.Join(targets, ","))
+
+	return append(withTargets, args[at:]...)
+}
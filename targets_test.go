@@ -0,0 +1,182 @@
+package RustScan
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTargetsFromMasscanJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			"array with trailing comma",
+			`[
+{   "ip": "10.0.0.1",   "timestamp": "1638862444", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "10.0.0.2",   "timestamp": "1638862444", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+]`,
+			[]string{"10.0.0.1:80", "10.0.0.2:22"},
+		},
+		{
+			"newline delimited",
+			`{"ip": "10.0.0.1", "ports": [{"port": 443, "status": "open"}]}
+{"ip": "10.0.0.1", "ports": [{"port": 443, "status": "open"}]}
+{"ip": "::1", "ports": [{"port": 8080}]}`,
+			[]string{"10.0.0.1:443", "[::1]:8080"},
+		},
+		{
+			"closed ports",
+			`[{"ip": "10.0.0.1", "ports": [{"port": 80, "status": "closed"}, {"port": 81, "status": "open"}]}]`,
+			[]string{"10.0.0.1:81"},
+		},
+		{"empty", ``, nil},
+	}
+
+	for _, test := range tests {
+		got, err := TargetsFromMasscanJSON(strings.NewReader(test.input))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	if _, err := TargetsFromMasscanJSON(strings.NewReader(`[{"ip": `)); err == nil {
+		t.Error("expected an error for truncated output")
+	}
+}
+
+func TestTargetsFromNessusCSV(t *testing.T) {
+	input := `Plugin ID,CVE,CVSS,Risk,Host,Protocol,Port,Name
+19506,,,None,10.0.0.1,tcp,0,Nessus Scan Information
+10107,,,None,10.0.0.1,tcp,80,HTTP Server Type and Version
+10267,,,None,10.0.0.1,tcp,22,"SSH Server Type and Version, Information"
+10107,,,None,10.0.0.1,tcp,80,HTTP Server Type and Version
+10287,,,None,10.0.0.2,udp,161,SNMP Agent Default Community Name
+`
+
+	got, err := TargetsFromNessusCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"10.0.0.1:80", "10.0.0.1:22", "10.0.0.2:161"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := TargetsFromNessusCSV(strings.NewReader("Plugin ID,Name\n1,x\n")); err == nil {
+		t.Error("expected an error without the Host and Port columns")
+	}
+}
+
+func TestGroupHostPortPairs(t *testing.T) {
+	groups, err := groupHostPortPairs([]string{"10.0.0.1:80", "10.0.0.2:443", " 10.0.0.1:22 ", "[::1]:80", "[::1]:22", "10.0.0.2:443", "10.0.0.3:443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []hostPortGroup{
+		{hosts: []string{"10.0.0.1", "::1"}, ports: []int{22, 80}},
+		{hosts: []string{"10.0.0.2", "10.0.0.3"}, ports: []int{443}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("expected %+v, got %+v", want, groups)
+	}
+
+	for _, pair := range []string{"10.0.0.1", "10.0.0.1:", ":80", "10.0.0.1:http", "10.0.0.1:0", "::1:80"} {
+		if _, err := groupHostPortPairs([]string{pair}); err == nil || !strings.Contains(err.Error(), pair) {
+			t.Errorf("%q: expected an error naming the pair, got %v", pair, err)
+		}
+	}
+}
+
+func TestWithHostPortPairs(t *testing.T) {
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithHostPortPairs("10.0.0.1:80", "10.0.0.2:22", "10.0.0.1:22"), WithCustomArguments("--", "-sV"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The hosts and ports of the pairs go before the nmap arguments.
+	want := [][]string{
+		{"-a", "10.0.0.1", "-p", "22,80", "--", "-sV", "-oX", "-"},
+		{"-a", "10.0.0.2", "-p", "22", "--", "-sV", "-oX", "-"},
+	}
+	if !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	if _, err := NewScanner(WithBinaryPath("rustscan"), WithHostPortPairs("10.0.0.1")); err == nil {
+		t.Error("expected an error for an invalid pair")
+	}
+}
+
+func TestWithHostPortPairsRun(t *testing.T) {
+	defer setFakeEnv(t, map[string]string{})()
+
+	result, _, err := newFakeScanner(t, WithHostPortPairs("10.0.0.1:80", "10.0.0.2:22", "10.0.0.1:22")).Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The runs of both processes are merged into one.
+	ports := make(map[string]int)
+	for _, host := range result.Hosts {
+		ports[host.Addresses[0].Addr] = len(host.Ports)
+	}
+	if want := map[string]int{"10.0.0.1": 2, "10.0.0.2": 1}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("expected ports %v, got %v", want, ports)
+	}
+}
+
+func TestWithAddressesFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "targets.txt")
+	if err := ioutil.WriteFile(path, []byte("10.0.0.2\n192.168.0.0/24\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner, err := NewScanner(WithBinaryPath("rustscan"), WithTargets("10.0.0.1"), WithAddressesFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocations, err := scanner.invocations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// RustScan reads the file given among the other targets.
+	if want := [][]string{{"-a", "10.0.0.1," + path, "--", "-oX", "-"}}; !reflect.DeepEqual(invocations, want) {
+		t.Errorf("expected %v, got %v", want, invocations)
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"missing file", []Option{WithAddressesFile(filepath.Join(dir, "missing.txt"))}},
+		{"directory", []Option{WithAddressesFile(dir)}},
+		{"forbidden ranges", []Option{WithAddressesFile(path), WithForbiddenCIDRs("10.0.0.2")}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewScanner(append([]Option{WithBinaryPath("rustscan")}, test.options...)...); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package RustScan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the options of the scanner for invalid values and combinations that
+// RustScan would reject, such as a flag set twice or a batch size
+// larger than the ulimit. It returns nil or a ValidationErrors listing every problem.
+// NewScanner validates the scanner once its options are applied, and Run validates it
+// again before starting a scan, since AddOptions may have changed them.
+func (s *Scanner) Validate() error {
+	var errs ValidationErrors
+	errs = append(errs, s.errs...)
+
+	// Flags RustScan only accepts once. Port lists and ranges are merged by Run.
+	for _, flag := range []string{"-b", "-t", "-u", "--tries", "--scan-order", "--scripts", "--config-path"} {
+		if values, _ := extractFlag(s.args, flag); len(values) > 1 {
+			errs = append(errs, fmt.Errorf("%s is set %d times", flag, len(values)))
+		}
+	}
+
+	if len(s.pairGroups) > 0 {
+		for _, flag := range []string{"-a", "-p", "-r", "--top"} {
+			if containsString(s.args, flag) {
+				errs = append(errs, fmt.Errorf("WithHostPortPairs cannot be combined with %s", flag))
+			}
+		}
+	}
+
+	if containsString(s.args, "--top") && (containsString(s.args, "-p") || containsString(s.args, "-r")) {
+		errs = append(errs, fmt.Errorf("WithTopPorts cannot be combined with a list or range of ports"))
+	}
+
+	if s.backoffBase > 0 && containsString(s.args, "-t") {
+		errs = append(errs, fmt.Errorf("WithTimeoutBackoff cannot be combined with a timeout (-t)"))
+	}
+
+	if containsString(s.nmapArgs, "-4") && containsString(s.nmapArgs, "-6") {
+		errs = append(errs, fmt.Errorf("WithForceIPv4 cannot be combined with WithIPv6"))
+	}
+
+	if s.perHostParallel > 0 && s.checkpointPath != "" {
+		errs = append(errs, fmt.Errorf("WithPerHostParallel cannot be combined with WithCheckpoint"))
+	}
+
+	if len(s.addressFiles) > 0 && len(s.forbidden) > 0 {
+		errs = append(errs, fmt.Errorf("WithAddressesFile cannot be combined with WithForbiddenCIDRs"))
+	}
+
+	if s.greppable && (len(s.nmapArgs) > 0 || len(s.scriptArgs) > 0) {
+		errs = append(errs, fmt.Errorf("WithGreppable cannot be combined with options of the nmap stage"))
+	}
+
+	if s.greppable && s.scriptMode != "" && s.scriptMode != ScriptsNone {
+		errs = append(errs, fmt.Errorf("WithGreppable cannot be combined with WithScripts(%s), greppable output runs no script", s.scriptMode))
+	}
+
+	for _, arg := range s.allNmapArgs() {
+		switch {
+		case arg == "--":
+			errs = append(errs, fmt.Errorf("nmap arguments cannot contain \"--\""))
+		case strings.HasPrefix(arg, "-oX") || strings.HasPrefix(arg, "-oA"):
+			errs = append(errs, fmt.Errorf("nmap arguments cannot set %s, the XML output is written to stdout", arg))
+		}
+	}
+
+	if containsString(s.args, "--no-config") && containsString(s.args, "--config-path") {
+		errs = append(errs, fmt.Errorf("WithConfigPath cannot be combined with WithNoConfig"))
+	}
+
+	if s.scriptMode == ScriptsNone && (len(s.nmapArgs) > 0 || len(s.scriptArgs) > 0) {
+		errs = append(errs, fmt.Errorf("WithScripts(ScriptsNone) cannot be combined with options of the nmap stage"))
+	}
+
+	batch, batchErr := positiveFlag(s.args, "-b", "batch size")
+	ulimit, ulimitErr := positiveFlag(s.args, "-u", "ulimit")
+	_, timeoutErr := positiveFlag(s.args, "-t", "timeout")
+	for _, err := range []error{batchErr, ulimitErr, timeoutErr} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if batch > 0 && ulimit > 0 && batch > ulimit {
+		errs = append(errs, fmt.Errorf("batch size %d exceeds the ulimit %d", batch, ulimit))
+	}
+
+	if orders, _ := extractFlag(s.args, "--scan-order"); len(orders) > 0 {
+		switch strings.ToLower(orders[0]) {
+		case "serial", "random":
+		default:
+			errs = append(errs, fmt.Errorf("invalid scan order %q, expected serial or random", orders[0]))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// positiveFlag returns the value of a flag that must be a positive integer, or zero if
+// the flag is not set.
+func positiveFlag(args []string, flag, name string) (int, error) {
+	values, _ := extractFlag(args, flag)
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(values[0])
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive number", name, values[0])
+	}
+
+	return value, nil
+}
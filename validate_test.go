@@ -0,0 +1,152 @@
+package RustScan
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		want    []string
+	}{
+		{"valid", []Option{WithTargets("10.0.0.1"), WithPorts("22"), WithBatchSize(500), WithUlimit(1000)}, nil},
+		{
+			"several problems",
+			[]Option{
+				WithTargets("10.0.0.1"),
+				WithBatchSize(5000),
+				WithUlimit(1000),
+				WithTimeout(100),
+				WithTimeout(200),
+				WithScanOrder("zigzag"),
+				WithNmapArguments("-oX", "out.xml"),
+				WithForceIPv4(),
+				WithIPv6(),
+			},
+			[]string{
+				"-t is set 2 times",
+				"WithForceIPv4 cannot be combined with WithIPv6",
+				"nmap arguments cannot set -oX",
+				"batch size 5000 exceeds the ulimit 1000",
+				`invalid scan order "zigzag"`,
+			},
+		},
+		{
+			"conflicting port modes",
+			[]Option{WithHostPortPairs("10.0.0.1:22"), WithTargets("10.0.0.2"), WithTopPorts(), WithPorts("80")},
+			[]string{
+				"WithHostPortPairs cannot be combined with -a",
+				"WithHostPortPairs cannot be combined with -p",
+				"WithHostPortPairs cannot be combined with --top",
+				"WithTopPorts cannot be combined with a list or range of ports",
+			},
+		},
+		{
+			"invalid values",
+			[]Option{WithTargets("10.0.0.1"), WithPorts("http"), WithBatchSize(-1), WithMaxRuntime(-time.Second)},
+			[]string{"invalid port", "invalid batch size \"-1\"", "invalid maximum runtime"},
+		},
+	}
+
+	for _, test := range tests {
+		scanner := &Scanner{}
+		for _, option := range test.options {
+			option(scanner)
+		}
+
+		err := scanner.Validate()
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: expected ValidationErrors, got %v", test.name, err)
+			continue
+		}
+
+		// Every problem is reported at once, in a single error.
+		if len(errs) != len(test.want) {
+			t.Errorf("%s: expected %d problems, got %d: %v", test.name, len(test.want), len(errs), err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected %q among the problems, got %v", test.name, want, err)
+			}
+		}
+	}
+}
+
+func TestNewScannerValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		want    string
+	}{
+		{"top ports and ports", []Option{WithTopPorts(), WithPorts("80")}, "WithTopPorts cannot be combined with a list or range of ports"},
+		{"greppable and scripts", []Option{WithGreppable(), WithScripts(ScriptsDefault)}, "WithGreppable cannot be combined with WithScripts(default)"},
+		{"flag set twice", []Option{WithBatchSize(1000), WithBatchSize(2000)}, "-b is set 2 times"},
+	}
+
+	// Conflicting options are reported when the scanner is created, before any scan.
+	for _, test := range tests {
+		scanner, err := NewScanner(append([]Option{WithBinaryPath("rustscan"), WithTargets("10.0.0.1")}, test.options...)...)
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected ValidationErrors with %q, got %v", test.name, test.want, err)
+		}
+		if scanner != nil {
+			t.Errorf("%s: expected no scanner", test.name)
+		}
+	}
+
+	// Options added later are validated by Validate and again by Run.
+	defer setFakeEnv(t, map[string]string{})()
+
+	scanner := newFakeScanner(t, WithTargets("10.0.0.1"), WithTopPorts())
+	if err := scanner.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	scanner.AddOptions(WithPorts("80"))
+	if err := scanner.Validate(); err == nil {
+		t.Error("expected Validate to report the added conflict")
+	}
+	if _, _, err := scanner.Run(); err == nil || !strings.Contains(err.Error(), "WithTopPorts cannot be combined") {
+		t.Errorf("expected Run to report the added conflict, got %v", err)
+	}
+}
+
+func TestValidationErrorsMatch(t *testing.T) {
+	errs := ValidationErrors{errors.New("-t is set 2 times"), fmt.Errorf("wrapped: %w", &CDNError{OpenPorts: 1})}
+
+	// The methods are called directly, since errors.Is and errors.As only unwrap a list
+	// of errors by themselves from Go 1.20 on.
+	if !errs.Is(ErrScanCDN) {
+		t.Error("expected the errors to match ErrScanCDN")
+	}
+	if errs.Is(ErrInvalidPort) {
+		t.Error("unexpected match of ErrInvalidPort")
+	}
+
+	var cdnErr *CDNError
+	if !errs.As(&cdnErr) || cdnErr.OpenPorts != 1 {
+		t.Errorf("expected the CDNError, got %v", cdnErr)
+	}
+	var timeoutErr *ScanTimeoutError
+	if errs.As(&timeoutErr) {
+		t.Error("unexpected match of a ScanTimeoutError")
+	}
+
+	_, err := NewScanner(WithBinaryPath("rustscan"), WithPorts("http"))
+	if !errors.Is(err, ErrInvalidPort) {
+		t.Errorf("expected ErrInvalidPort, got %v", err)
+	}
+}
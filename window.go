@@ -0,0 +1,35 @@
+package RustScan
+
+import "time"
+
+// scanWindow is the period during which scans are allowed, see WithAllowedWindow.
+type scanWindow struct {
+	start, end time.Time
+}
+
+// daily reports whether the window repeats every day, which is the case when its
+// bounds have no date.
+func (w scanWindow) daily() bool {
+	return w.start.Year() == 0 && w.end.Year() == 0
+}
+
+// contains reports whether t is within the window.
+func (w scanWindow) contains(t time.Time) bool {
+	if !w.daily() {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+
+	// Compare the times of day in the location of the window, a window ending before it
+	// starts spanning midnight.
+	clock := func(t time.Time) time.Duration {
+		hour, min, sec := t.Clock()
+		return time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	}
+
+	now, start, end := clock(t.In(w.start.Location())), clock(w.start), clock(w.end)
+	if start <= end {
+		return now >= start && now < end
+	}
+
+	return now >= start || now < end
+}
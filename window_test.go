@@ -0,0 +1,76 @@
+package RustScan
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanWindowContains(t *testing.T) {
+	clock := func(value string) time.Time {
+		parsed, err := time.Parse("15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	date := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name   string
+		window scanWindow
+		at     string
+		want   bool
+	}{
+		{"daily inside", scanWindow{clock("09:00"), clock("17:00")}, "2021-12-07 12:00", true},
+		{"daily start", scanWindow{clock("09:00"), clock("17:00")}, "2021-12-07 09:00", true},
+		{"daily end", scanWindow{clock("09:00"), clock("17:00")}, "2021-12-07 17:00", false},
+		{"daily outside", scanWindow{clock("09:00"), clock("17:00")}, "2021-12-08 03:00", false},
+		{"overnight before midnight", scanWindow{clock("22:00"), clock("06:00")}, "2021-12-07 23:30", true},
+		{"overnight after midnight", scanWindow{clock("22:00"), clock("06:00")}, "2021-12-08 05:59", true},
+		{"overnight outside", scanWindow{clock("22:00"), clock("06:00")}, "2021-12-08 12:00", false},
+		{"dated inside", scanWindow{date("2021-12-07 22:00"), date("2021-12-08 06:00")}, "2021-12-08 01:00", true},
+		{"dated another day", scanWindow{date("2021-12-07 22:00"), date("2021-12-08 06:00")}, "2021-12-09 01:00", false},
+	}
+
+	for _, test := range tests {
+		if got := test.window.contains(date(test.at)); got != test.want {
+			t.Errorf("%s: expected %v at %s, got %v", test.name, test.want, test.at, got)
+		}
+	}
+}
+
+func TestWithAllowedWindow(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	argsFile := filepath.Join(dir, "args")
+	defer setFakeEnv(t, map[string]string{"FAKE_ARGS_FILE": argsFile})()
+
+	now := time.Now()
+
+	// Outside the window, RustScan does not start.
+	_, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithAllowedWindow(now.Add(-2*time.Hour), now.Add(-time.Hour))).Run()
+	if !errors.Is(err, ErrOutsideScanWindow) {
+		t.Errorf("expected ErrOutsideScanWindow, got %v", err)
+	}
+	if _, err := os.Stat(argsFile); !os.IsNotExist(err) {
+		t.Error("expected RustScan not to run outside the window")
+	}
+
+	if _, _, err := newFakeScanner(t, WithTargets("10.0.0.1"), WithAllowedWindow(now.Add(-time.Hour), now.Add(time.Hour))).Run(); err != nil {
+		t.Errorf("unexpected error within the window: %v", err)
+	}
+
+	if _, err := NewScanner(WithAllowedWindow(now, now.Add(-time.Hour))); err == nil {
+		t.Error("expected an error for a window ending before it starts")
+	}
+}
@@ -2,9 +2,11 @@ package RustScan
 
 import (
 	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"io"
 	"io/ioutil"
+	"math"
 	"strconv"
 	"time"
 
@@ -13,7 +15,7 @@ import (
 
 // Run represents an nmap scanning run.
 type Run struct {
-	XMLName xml.Name `xml:"nmaprun"`
+	XMLName xml.Name `xml:"nmaprun" json:"-"`
 
 	Args             string         `xml:"args,attr" json:"args"`
 	ProfileName      string         `xml:"profile_name,attr" json:"profile_name"`
@@ -34,8 +36,30 @@ type Run struct {
 	TaskProgress     []TaskProgress `xml:"taskprogress" json:"task_progress"`
 	TaskEnd          []Task         `xml:"taskend" json:"task_end"`
 
-	NmapErrors []string
+	// Phases are the stages the scan went through, as reported on RustScan's output.
+	Phases []Phase `xml:"-" json:"phases,omitempty"`
+
+	// FilterStats are the numbers of ports and hosts removed by each stage of the filter
+	// chain set with WithFilterChain, when it records them.
+	FilterStats []FilterStat `xml:"-" json:"filter_stats,omitempty"`
+
+	// UlimitWarning is set when RustScan warned that the open file limit is too low
+	// during the scan that produced the run.
+	UlimitWarning *UlimitWarning `xml:"-" json:"ulimit_warning,omitempty"`
+
+	// ScanID identifies the scan that produced the run, see WithScanID.
+	ScanID string `xml:"-" json:"scan_id,omitempty"`
+	// Name is the label set with WithScanName on the scanner that produced the run.
+	Name string `xml:"-" json:"name,omitempty"`
+	// Metadata holds the values set with WithMetadata on the scanner that produced the run.
+	Metadata map[string]string `xml:"-" json:"metadata,omitempty"`
+
+	NmapErrors []string `json:"nmap_errors"`
 	rawXML     []byte
+
+	// hostIndex maps the addresses of the hosts to their index, see HostByAddress.
+	hostIndex      map[string]int
+	hostIndexHosts int
 }
 
 // ToFile writes a Run as XML into the specified file path.
@@ -48,6 +72,75 @@ func (r Run) ToReader() io.Reader {
 	return bytes.NewReader(r.rawXML)
 }
 
+// WriteXML writes the raw XML the Run was parsed from to w, so that it can be archived
+// and parsed again later. It returns ErrNoRawXML when the Run was not parsed from XML,
+// was parsed from a stream, or comes from a scan run with WithMinimalMemory.
+func (r *Run) WriteXML(w io.Writer) error {
+	if len(r.rawXML) == 0 {
+		return ErrNoRawXML
+	}
+
+	_, err := w.Write(r.rawXML)
+	return err
+}
+
+// ToJSON encodes a Run as JSON, for pipelines that do not consume XML. The fields
+// follow the struct tags: the keys are the snake case names of nmap's elements and
+// attributes, timestamps are Unix times in seconds, and the scripts, OS matches and
+// other nested data of the hosts are included.
+func (r *Run) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// HostByAddress returns the host that has the given address, IP or MAC, and whether
+// there is one. The hosts are indexed by address on the first call, which makes the
+// following lookups constant time; the index is rebuilt when hosts are added or removed.
+// The returned host points into Hosts, and HostByAddress must not be called concurrently.
+func (r *Run) HostByAddress(addr string) (*Host, bool) {
+	if r.hostIndex == nil || r.hostIndexHosts != len(r.Hosts) {
+		r.indexHosts()
+	}
+
+	idx, ok := r.hostIndex[addr]
+	if !ok {
+		return nil, false
+	}
+
+	// The hosts may have been replaced since they were indexed.
+	if !hasAddress(r.Hosts[idx], addr) {
+		r.indexHosts()
+		if idx, ok = r.hostIndex[addr]; !ok {
+			return nil, false
+		}
+	}
+
+	return &r.Hosts[idx], true
+}
+
+// indexHosts maps every address of the hosts to the index of the first host having it.
+func (r *Run) indexHosts() {
+	r.hostIndex = make(map[string]int, len(r.Hosts))
+	r.hostIndexHosts = len(r.Hosts)
+
+	for idx, host := range r.Hosts {
+		for _, address := range host.Addresses {
+			if _, ok := r.hostIndex[address.Addr]; !ok {
+				r.hostIndex[address.Addr] = idx
+			}
+		}
+	}
+}
+
+func hasAddress(host Host, addr string) bool {
+	for _, address := range host.Addresses {
+		if address.Addr == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ScanInfo represents the scan information.
 type ScanInfo struct {
 	NumServices int    `xml:"numservices,attr" json:"num_services"`
@@ -112,6 +205,10 @@ type Host struct {
 	HostScripts   []Script      `xml:"hostscript>script" json:"host_scripts"`
 	Ports         []Port        `xml:"ports>port" json:"ports"`
 	Smurfs        []Smurf       `xml:"smurf" json:"smurfs"`
+
+	// Geo is the location of the first IP address of the host, set when scanning with
+	// WithGeoIP.
+	Geo *GeoInfo `xml:"-" json:"geo,omitempty"`
 }
 
 // Status represents a host's status.
@@ -173,6 +270,9 @@ type Port struct {
 	Service  Service  `xml:"service" json:"service"`
 	State    State    `xml:"state" json:"state"`
 	Scripts  []Script `xml:"script" json:"scripts"`
+
+	// RiskTier is the risk tier of the port, set when scanning with WithRiskTierOrder.
+	RiskTier string `xml:"-" json:"risk_tier,omitempty"`
 }
 
 // PortStatus represents a port's state.
@@ -184,6 +284,12 @@ const (
 	Closed     PortStatus = "closed"
 	Filtered   PortStatus = "filtered"
 	Unfiltered PortStatus = "unfiltered"
+	// OpenFiltered is the state of UDP ports that did not answer, which nmap cannot
+	// tell apart from filtered ports.
+	OpenFiltered PortStatus = "open|filtered"
+	// ClosedFiltered is the state of ports nmap cannot tell apart from filtered ports
+	// during an IP ID idle scan.
+	ClosedFiltered PortStatus = "closed|filtered"
 )
 
 // Status returns the status of a port.
@@ -263,6 +369,46 @@ type Element struct {
 	Value string `xml:",innerxml" json:"value"`
 }
 
+// ElementMap flattens the elements of a script into a map. The key of an element in a
+// table is prefixed with the keys of the tables holding it, joined by dots, such as
+// "title" or "vulns.1.id". Elements and tables without a key are numbered from 1 within
+// their parent, as in the Lua tables of the scripts.
+func (s Script) ElementMap() map[string]string {
+	elements := make(map[string]string)
+	flattenElements(elements, "", s.Elements, s.Tables)
+
+	return elements
+}
+
+func flattenElements(elements map[string]string, prefix string, elems []Element, tables []Table) {
+	index := 0
+	key := func(k string) string {
+		if k == "" {
+			index++
+			k = strconv.Itoa(index)
+		}
+		return prefix + k
+	}
+
+	for _, elem := range elems {
+		elements[key(elem.Key)] = elem.Value
+	}
+	for _, table := range tables {
+		flattenElements(elements, key(table.Key)+".", table.Elements, table.Tables)
+	}
+}
+
+// Script returns the output of the script with the given ID that ran against the port.
+func (p Port) Script(id string) (Script, bool) {
+	for _, script := range p.Scripts {
+		if script.ID == id {
+			return script, true
+		}
+	}
+
+	return Script{}, false
+}
+
 // OS contains the fingerprinted operating system for a host.
 type OS struct {
 	PortsUsed    []PortUsed      `xml:"portused" json:"ports_used"`
@@ -363,6 +509,22 @@ type Stats struct {
 	Hosts    HostStats `xml:"hosts" json:"hosts"`
 }
 
+// ElapsedDuration returns the duration of the scan, which nmap reports in seconds with
+// a precision of 10ms, rounded to the millisecond. It returns zero when the duration is
+// missing or not valid.
+func (s *Stats) ElapsedDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	elapsed := float64(s.Finished.Elapsed)
+	if math.IsNaN(elapsed) || math.IsInf(elapsed, 0) || elapsed <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Round(elapsed*1000)) * time.Millisecond
+}
+
 // Finished contains detailed statistics regarding a finished scan.
 type Finished struct {
 	Time     Timestamp `xml:"time,attr" json:"time"`
@@ -425,13 +587,156 @@ func (t *Timestamp) UnmarshalXMLAttr(attr xml.Attr) (err error) {
 }
 
 // Parse takes a byte array of nmap xml data and unmarshals it into a
-// Run struct.
+// Run struct. It keeps the raw XML for ToFile and ToReader.
 func Parse(content []byte) (*Run, error) {
-	r := &Run{
-		rawXML: content,
+	r, err := ParseReader(bytes.NewReader(content))
+	r.rawXML = content
+
+	return r, err
+}
+
+// ParseReader decodes nmap xml data from a reader into a Run struct, without loading
+// it in memory first, which suits large files and streams. The raw XML is not kept, so
+// ToFile and ToReader have nothing to write. It is equivalent to ParseStream, and
+// shares its decoding.
+func ParseReader(reader io.Reader) (*Run, error) {
+	return parseStream(reader, nil)
+}
+
+// ParseStream decodes nmap xml data from a reader into a Run struct incrementally: the
+// elements of the run, such as its hosts, are decoded one at a time as the reader
+// delivers them, so that only the decoded result is held in memory. It produces the
+// same Run as Parse, except that the raw XML is not kept, so ToFile and ToReader have
+// nothing to write. When the data is cut short, the returned Run holds the elements
+// decoded before the error.
+func ParseStream(reader io.Reader) (*Run, error) {
+	return parseStream(reader, nil)
+}
+
+// parseStream is ParseStream, calling onHost with every host as soon as it is decoded
+// unless onHost is nil.
+func parseStream(reader io.Reader, onHost func(Host)) (*Run, error) {
+	r := &Run{}
+	defer r.dedupePorts()
+
+	decoder := xml.NewDecoder(reader)
+
+	var root *xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF && root != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return r, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if root == nil {
+				start := token.Copy()
+				root = &start
+
+				// Decode the attributes of the run.
+				if err := xml.NewTokenDecoder(&elementReader{root: root}).Decode(r); err != nil {
+					return r, err
+				}
+				continue
+			}
+
+			if err := decodeRunElement(r, decoder, root, token, onHost); err != nil {
+				return r, err
+			}
+		case xml.EndElement:
+			if root != nil && token.Name == root.Name {
+				return r, nil
+			}
+		}
 	}
+}
 
-	err := xml.Unmarshal(content, r)
+// decodeRunElement decodes a child element of the run and adds it to r. Hosts and
+// scripts are decoded straight from the decoder, since their elements keep their inner
+// XML, which a token stream does not have.
+func decodeRunElement(r *Run, decoder *xml.Decoder, root *xml.StartElement, start xml.StartElement, onHost func(Host)) error {
+	switch start.Name.Local {
+	case "host":
+		var host Host
+		if err := decoder.DecodeElement(&host, &start); err != nil {
+			return err
+		}
+		r.Hosts = append(r.Hosts, host)
+		if onHost != nil {
+			host.Ports = dedupePorts(host.Ports)
+			onHost(host)
+		}
+	case "prescript", "postscript":
+		var scripts struct {
+			Scripts []Script `xml:"script"`
+		}
+		if err := decoder.DecodeElement(&scripts, &start); err != nil {
+			return err
+		}
+		if start.Name.Local == "prescript" {
+			r.PreScripts = append(r.PreScripts, scripts.Scripts...)
+		} else {
+			r.PostScripts = append(r.PostScripts, scripts.Scripts...)
+		}
+	default:
+		// Decode the element as the only child of the run, which adds it to r.
+		child := &elementReader{root: root, decoder: decoder, start: start.Copy()}
+		return xml.NewTokenDecoder(child).Decode(r)
+	}
 
-	return r, err
+	return nil
+}
+
+// elementReader reads a single child element of the root element of a decoder, wrapped
+// in the root element, so that decoding it into the root's struct only adds the child.
+type elementReader struct {
+	root    *xml.StartElement
+	decoder *xml.Decoder
+	start   xml.StartElement
+
+	step  int
+	depth int
+}
+
+func (e *elementReader) Token() (xml.Token, error) {
+	e.step++
+
+	switch {
+	case e.step == 1:
+		return *e.root, nil
+	case e.step == 2 && e.decoder != nil:
+		e.depth = 1
+		return e.start, nil
+	case e.depth > 0:
+		token, err := e.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			e.depth++
+		case xml.EndElement:
+			e.depth--
+		}
+
+		return xml.CopyToken(token), nil
+	case e.depth == 0:
+		e.depth = -1
+		return e.root.End(), nil
+	default:
+		return nil, io.EOF
+	}
+}
+
+// dedupePorts counts a port listed more than once on a host once, with its most
+// detailed entry.
+func (r *Run) dedupePorts() {
+	for idx := range r.Hosts {
+		r.Hosts[idx].Ports = dedupePorts(r.Hosts[idx].Ports)
+	}
 }
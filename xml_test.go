@@ -0,0 +1,570 @@
+package RustScan
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	family "github.com/yhy0/RustScan/pkg/osfamilies"
+)
+
+// parsers are the entry points parsing nmap's XML output, which give the same Run.
+var parsers = []struct {
+	name  string
+	parse func(content []byte) (*Run, error)
+}{
+	{"Parse", Parse},
+	{"ParseReader", func(content []byte) (*Run, error) { return ParseReader(bytes.NewReader(content)) }},
+	{"ParseStream", func(content []byte) (*Run, error) { return ParseStream(bytes.NewReader(content)) }},
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	content, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+func TestParseScripts(t *testing.T) {
+	content := readFixture(t, "scripts.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 1 || len(run.Hosts[0].Ports) != 2 {
+			t.Fatalf("%s: expected 1 host with 2 ports, got %+v", parser.name, run.Hosts)
+		}
+		host := run.Hosts[0]
+
+		// Each script is attached to the port it ran against.
+		scripts := make(map[uint16][]string)
+		for _, port := range host.Ports {
+			for _, script := range port.Scripts {
+				scripts[port.ID] = append(scripts[port.ID], script.ID)
+			}
+		}
+		if want := map[uint16][]string{22: {"ssh-hostkey"}, 80: {"http-title", "http-server-header"}}; !reflect.DeepEqual(scripts, want) {
+			t.Errorf("%s: expected port scripts %v, got %v", parser.name, want, scripts)
+		}
+
+		var hostScripts []string
+		for _, script := range host.HostScripts {
+			hostScripts = append(hostScripts, script.ID)
+		}
+		if want := []string{"smb-os-discovery", "clock-skew"}; !reflect.DeepEqual(hostScripts, want) {
+			t.Errorf("%s: expected host scripts %v, got %v", parser.name, want, hostScripts)
+		}
+
+		title, ok := host.Ports[1].Script("http-title")
+		if !ok || title.Output != "Welcome" {
+			t.Errorf("%s: expected the http-title script on port 80, got %+v", parser.name, title)
+		}
+		if _, ok := host.Ports[0].Script("http-title"); ok {
+			t.Errorf("%s: unexpected http-title script on port 22", parser.name)
+		}
+	}
+}
+
+func TestParseRunAttributes(t *testing.T) {
+	content := readFixture(t, "merge1.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+
+		got := []string{run.Scanner, run.Args, run.Version, run.XMLOutputVersion, run.StartStr}
+		want := []string{"nmap", "nmap -sV -p 22,80 -oX - 10.0.0.1 10.0.0.2", "7.92", "1.05", "Tue Dec  7 15:34:04 2021"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", parser.name, want, got)
+		}
+
+		if start := time.Time(run.Start); !start.Equal(time.Unix(1638862444, 0)) {
+			t.Errorf("%s: expected start at 1638862444, got %v", parser.name, start)
+		}
+	}
+}
+
+func TestParseSCTPPorts(t *testing.T) {
+	content := readFixture(t, "sctp.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 1 {
+			t.Fatalf("%s: expected 1 host, got %d", parser.name, len(run.Hosts))
+		}
+
+		// The ports of every <ports> section are gathered on the host.
+		var ports []string
+		for _, port := range run.Hosts[0].Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s %s", port.ID, port.Protocol, port.Status()))
+		}
+		if want := []string{"22/tcp open", "2905/sctp open", "3868/sctp filtered"}; !reflect.DeepEqual(ports, want) {
+			t.Errorf("%s: expected %q, got %q", parser.name, want, ports)
+		}
+	}
+}
+
+func TestHostByAddress(t *testing.T) {
+	run, err := Parse(readFixture(t, "merge2.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		addr  string
+		ok    bool
+		ports int
+	}{
+		{"10.0.0.1", true, 2},
+		{"10.0.0.3", true, 1},
+		{"10.0.0.2", false, 0},
+		{"", false, 0},
+	}
+
+	for _, test := range tests {
+		host, ok := run.HostByAddress(test.addr)
+		if ok != test.ok {
+			t.Errorf("%q: expected %v, got %v", test.addr, test.ok, ok)
+			continue
+		}
+		if ok && (host.Addresses[0].Addr != test.addr || len(host.Ports) != test.ports) {
+			t.Errorf("%q: expected the host with %d ports, got %+v", test.addr, test.ports, host)
+		}
+	}
+
+	// The index follows the hosts when they change.
+	run.Hosts = append(run.Hosts[:1:1], newHost("10.0.0.4", Status{State: "up"}))
+	if _, ok := run.HostByAddress("10.0.0.3"); ok {
+		t.Error("expected a removed host to be absent")
+	}
+	if host, ok := run.HostByAddress("10.0.0.4"); !ok || host != &run.Hosts[1] {
+		t.Error("expected an added host to be found in place")
+	}
+}
+
+func TestParseDuplicatePorts(t *testing.T) {
+	content := readFixture(t, "duplicates.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 1 {
+			t.Fatalf("%s: expected 1 host, got %d", parser.name, len(run.Hosts))
+		}
+
+		// A port is listed once per protocol, with its most detailed entry.
+		var ports []string
+		for _, port := range run.Hosts[0].Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s %s", port.ID, port.Protocol, port.Service.Product))
+		}
+		if want := []string{"80/tcp nginx", "53/tcp ", "53/udp "}; !reflect.DeepEqual(ports, want) {
+			t.Errorf("%s: expected %q, got %q", parser.name, want, ports)
+		}
+	}
+}
+
+func TestElapsedDuration(t *testing.T) {
+	tests := []struct {
+		elapsed float32
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{0.25, 250 * time.Millisecond},
+		{61.37, 61370 * time.Millisecond},
+		{0, 0},
+		{-1, 0},
+		{float32(math.NaN()), 0},
+		{float32(math.Inf(1)), 0},
+	}
+
+	for _, test := range tests {
+		stats := &Stats{Finished: Finished{Elapsed: test.elapsed}}
+		if got := stats.ElapsedDuration(); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.elapsed, test.want, got)
+		}
+	}
+
+	var stats *Stats
+	if got := stats.ElapsedDuration(); got != 0 {
+		t.Errorf("expected zero for nil stats, got %v", got)
+	}
+
+	run, err := Parse(readFixture(t, "merge2.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := run.Stats.ElapsedDuration(); got != time.Minute {
+		t.Errorf("expected the minute of the fixture, got %v", got)
+	}
+}
+
+// failingReader returns its error once the data is read.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestParseReader(t *testing.T) {
+	file, err := os.Open("testdata/merge1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	run, err := ParseReader(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := Parse(readFixture(t, "merge1.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The run is the one Parse gives, without the raw XML.
+	if !reflect.DeepEqual(run.Hosts, want.Hosts) || run.Stats != want.Stats {
+		t.Errorf("expected the run Parse gives, got %+v", run)
+	}
+	if err := run.WriteXML(ioutil.Discard); err != ErrNoRawXML {
+		t.Errorf("expected ErrNoRawXML, got %v", err)
+	}
+
+	// The error of the reader is returned, along with the hosts decoded before it.
+	readErr := errors.New("connection reset")
+	content := readFixture(t, "merge1.xml")
+	run, err = ParseReader(&failingReader{data: content[:bytes.Index(content, []byte("<runstats>"))], err: readErr})
+	if !errors.Is(err, readErr) {
+		t.Errorf("expected the error of the reader, got %v", err)
+	}
+	if run == nil || len(run.Hosts) != 2 {
+		t.Errorf("expected the 2 hosts decoded before the error, got %+v", run)
+	}
+}
+
+func TestParseUDPPorts(t *testing.T) {
+	content := readFixture(t, "udp.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 1 {
+			t.Fatalf("%s: expected 1 host, got %d", parser.name, len(run.Hosts))
+		}
+
+		statuses := make(map[uint16]PortStatus)
+		for _, port := range run.Hosts[0].Ports {
+			if port.Protocol != "udp" {
+				t.Errorf("%s: expected UDP ports, got %s", parser.name, port.Protocol)
+			}
+			statuses[port.ID] = port.Status()
+		}
+		if want := map[uint16]PortStatus{53: Open, 123: OpenFiltered, 161: Closed}; !reflect.DeepEqual(statuses, want) {
+			t.Errorf("%s: expected %v, got %v", parser.name, want, statuses)
+		}
+	}
+}
+
+func TestScriptElementMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		want   map[string]string
+	}{
+		{"empty", Script{ID: "clock-skew"}, map[string]string{}},
+		{"keyed elements", Script{Elements: []Element{{Key: "title", Value: "Welcome"}}}, map[string]string{"title": "Welcome"}},
+		{
+			"unkeyed elements",
+			Script{Elements: []Element{{Value: "nginx"}, {Key: "version", Value: "1.18"}, {Value: "ubuntu"}}},
+			map[string]string{"1": "nginx", "version": "1.18", "2": "ubuntu"},
+		},
+		{
+			"nested tables",
+			Script{Tables: []Table{{Key: "vulns", Tables: []Table{
+				{Elements: []Element{{Key: "id", Value: "CVE-2021-1"}}},
+				{Elements: []Element{{Key: "id", Value: "CVE-2021-2"}, {Key: "state", Value: "VULNERABLE"}}},
+			}}}},
+			map[string]string{"vulns.1.id": "CVE-2021-1", "vulns.2.id": "CVE-2021-2", "vulns.2.state": "VULNERABLE"},
+		},
+		{
+			"elements and tables numbered together",
+			Script{Elements: []Element{{Value: "a"}}, Tables: []Table{{Elements: []Element{{Key: "b", Value: "c"}}}}},
+			map[string]string{"1": "a", "2.b": "c"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.script.ElementMap(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	run, err := Parse(readFixture(t, "scripts.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hostkey, ok := run.Hosts[0].Ports[0].Script("ssh-hostkey")
+	if !ok {
+		t.Fatal("expected the ssh-hostkey script on port 22")
+	}
+	if want := map[string]string{"1.type": "ssh-rsa", "1.bits": "3072"}; !reflect.DeepEqual(hostkey.ElementMap(), want) {
+		t.Errorf("expected %v, got %v", want, hostkey.ElementMap())
+	}
+}
+
+func TestParseRunScripts(t *testing.T) {
+	content := readFixture(t, "runscripts.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 2 {
+			t.Fatalf("%s: expected 2 hosts, got %d", parser.name, len(run.Hosts))
+		}
+
+		// The scripts that run before and after the hosts belong to the run, not a host.
+		want := []Script{
+			{ID: "broadcast-ping", Output: "No hosts found"},
+			{ID: "targets-asn", Output: "AS64500", Elements: []Element{{Key: "asn", Value: "64500"}}},
+		}
+		if !reflect.DeepEqual(run.PreScripts, want) {
+			t.Errorf("%s: expected pre-scan scripts %+v, got %+v", parser.name, want, run.PreScripts)
+		}
+
+		want = []Script{
+			{ID: "ssh-hostkey", Output: "Possible duplicate SSH keys", Elements: []Element{{Key: "key", Value: "aa:bb"}}},
+		}
+		if !reflect.DeepEqual(run.PostScripts, want) {
+			t.Errorf("%s: expected post-scan scripts %+v, got %+v", parser.name, want, run.PostScripts)
+		}
+	}
+}
+
+func TestParseOSMatches(t *testing.T) {
+	content := readFixture(t, "os.xml")
+
+	for _, parser := range parsers {
+		run, err := parser.parse(content)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", parser.name, err)
+		}
+		if len(run.Hosts) != 2 {
+			t.Fatalf("%s: expected 2 hosts, got %d", parser.name, len(run.Hosts))
+		}
+
+		// The candidates are kept in the order nmap ranks them, best first.
+		var matches []string
+		for _, match := range run.Hosts[0].OS.Matches {
+			matches = append(matches, fmt.Sprintf("%s %d %d", match.Name, match.Accuracy, match.Line))
+		}
+		if want := []string{"Linux 4.15 - 5.6 96 65478", "Linux 2.6.32 91 55543"}; !reflect.DeepEqual(matches, want) {
+			t.Errorf("%s: expected %q, got %q", parser.name, want, matches)
+		}
+
+		best := run.Hosts[0].OS.Matches[0]
+		if len(best.Classes) != 2 || best.Classes[1].OSGeneration != "5.X" || best.Classes[1].OSFamily() != family.Linux {
+			t.Errorf("%s: expected the two Linux classes of the best match, got %+v", parser.name, best.Classes)
+		}
+
+		// The ports nmap probed to fingerprint the host, open and closed alike.
+		want := []PortUsed{{State: "open", Proto: "tcp", ID: 22}, {State: "closed", Proto: "tcp", ID: 1}, {State: "closed", Proto: "udp", ID: 43210}}
+		if used := run.Hosts[0].OS.PortsUsed; !reflect.DeepEqual(used, want) {
+			t.Errorf("%s: expected ports used %+v, got %+v", parser.name, want, used)
+		}
+
+		// A host nmap could not fingerprint has no matches.
+		if os := run.Hosts[1].OS; len(os.Matches) != 0 || len(os.PortsUsed) != 0 {
+			t.Errorf("%s: expected no OS data for the second host, got %+v", parser.name, os)
+		}
+	}
+}
+
+func TestParseStreamIncremental(t *testing.T) {
+	content := readFixture(t, "merge1.xml")
+	split := bytes.Index(content, []byte("</host>")) + len("</host>")
+
+	reader, writer := io.Pipe()
+	hosts := make(chan Host, 2)
+	result := make(chan error, 1)
+	go func() {
+		_, err := parseStream(reader, func(host Host) { hosts <- host })
+		result <- err
+	}()
+
+	// The first host is handed over before the rest of the output is written.
+	if _, err := writer.Write(content[:split]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case host := <-hosts:
+		if addr := host.Addresses[0].Addr; addr != "10.0.0.1" {
+			t.Errorf("expected the first host, got %s", addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the first host before the end of the output")
+	}
+
+	if _, err := writer.Write(content[split:]); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if host := <-hosts; host.Addresses[0].Addr != "10.0.0.2" {
+		t.Errorf("expected the second host, got %+v", host)
+	}
+}
+
+func TestParseStreamTruncated(t *testing.T) {
+	content := readFixture(t, "merge1.xml")
+	end := bytes.LastIndex(content, []byte("</host>"))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		hosts int
+	}{
+		{"between hosts", content[:end+len("</host>")], 2},
+		{"within a host", content[:end], 1},
+	}
+
+	// The hosts decoded before the output was cut short are returned along with the error.
+	for _, test := range tests {
+		run, err := ParseStream(bytes.NewReader(test.data))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if run == nil || len(run.Hosts) != test.hosts || run.Version != "7.92" {
+			t.Errorf("%s: expected the run attributes and %d hosts, got %+v", test.name, test.hosts, run)
+		}
+	}
+
+	if _, err := ParseStream(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("expected io.EOF for empty output, got %v", err)
+	}
+}
+
+func TestRunToJSON(t *testing.T) {
+	for _, fixture := range []string{"os.xml", "scripts.xml"} {
+		run, err := Parse(readFixture(t, fixture))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+
+		encoded, err := run.ToJSON()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+
+		// The nested data of the hosts survives a round trip.
+		var decoded Run
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+		reencoded, err := decoded.ToJSON()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+		if !bytes.Equal(reencoded, encoded) {
+			t.Errorf("%s: expected the same JSON once decoded, got\n%s\ninstead of\n%s", fixture, reencoded, encoded)
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+		if start, ok := fields["start"].(float64); !ok || start != 1638862444 {
+			t.Errorf("%s: expected the start as a Unix time, got %v", fixture, fields["start"])
+		}
+		for _, key := range []string{"hosts", "run_stats", "xml_output_version", "nmap_errors"} {
+			if _, ok := fields[key]; !ok {
+				t.Errorf("%s: expected the %q key", fixture, key)
+			}
+		}
+		if _, ok := fields["XMLName"]; ok {
+			t.Errorf("%s: unexpected XMLName key", fixture)
+		}
+	}
+
+	run, err := Parse(readFixture(t, "scripts.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encoded, err := run.ToJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{`"id":"http-title"`, `"key":"title","value":"Welcome"`, `"host_scripts":[{"id":"smb-os-discovery"`} {
+		if !bytes.Contains(encoded, []byte(want)) {
+			t.Errorf("expected %s in %s", want, encoded)
+		}
+	}
+}
+
+func TestRunWriteXML(t *testing.T) {
+	content := readFixture(t, "merge1.xml")
+	run, err := Parse(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The XML is written as it was parsed, and parses into the same run.
+	var out bytes.Buffer
+	if err := run.WriteXML(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("expected the parsed XML, got %s", out.Bytes())
+	}
+	reparsed, err := Parse(out.Bytes())
+	if err != nil || !reflect.DeepEqual(reparsed.Hosts, run.Hosts) {
+		t.Errorf("expected the XML to parse into the same hosts, got %v", err)
+	}
+
+	if err := run.WriteXML(failingWriter{}); err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the error of the writer, got %v", err)
+	}
+
+	for name, run := range map[string]*Run{"empty": {}, "no open ports": noOpenPortsRun([]string{"10.0.0.1"}, nil)} {
+		if err := run.WriteXML(&out); err != ErrNoRawXML {
+			t.Errorf("%s: expected ErrNoRawXML, got %v", name, err)
+		}
+	}
+}